@@ -0,0 +1,827 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetFieldString finds a field by its mapped name and sets it to value parsed
+// according to the field's kind.  Strings, bools, signed and unsigned
+// integers and floats of all sizes are supported, empty interfaces are set to
+// the string and other interfaces are an error, for slices the parsed value
+// is appended so that calling it repeatedly collects all the values, for
+// arrays the first element is set, use SetFieldStrings to set all elements.
+// Nil pointers on the way to the field are allocated, v must be addressable.
+// Fields implementing encoding.TextUnmarshaler are set using UnmarshalText.
+// For slice fields with the "split" tag option the value is split on the
+// option's value and every part is appended, ex. with `http:"l,split=;"` the
+// value "a;b" appends "a" and "b", the empty option value ex. `http:"l,split"`
+// splits on commas.
+// Panics if v's Kind is not Struct or v is not Indirectable to a struct Kind.
+func (m *Mapper) SetFieldString(v reflect.Value, name, value string) error {
+	return m.SetFieldStrings(v, name, []string{value})
+}
+
+// SetFieldStrings is like SetFieldString but it sets all the values, ex. all
+// the values of a form parameter.  For arrays the consecutive elements are set
+// to the values, it's an error if there are more values than elements.  For
+// slices all the values are appended, for other kinds the values are set in
+// turn so the last value wins.  If the name is not found and it has a
+// bracketed key suffix, ex. "meta[color]", the values are set to the key of
+// the map field with the base name like in SetMapEntry.
+func (m *Mapper) SetFieldStrings(v reflect.Value, name string, values []string) error {
+	v = allocIndirect(v)
+	mustBe(v, reflect.Struct)
+
+	fi, ok := m.TypeMap(v.Type()).lookup(name)
+	if !ok {
+		if base, key, ok := splitMapKey(name); ok {
+			for _, value := range values {
+				if err := m.SetMapEntry(v, base, key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return &FieldNotFoundError{Name: name, Type: v.Type()}
+	}
+	f, err := fi.SettableValue(v)
+	if err != nil {
+		return err
+	}
+	if err := setStrings(f, splitValues(fi, values), m.parsers()); err != nil {
+		return fieldError(name, err)
+	}
+	return nil
+}
+
+// splitValues splits the values on the value of the "split" option of a slice
+// field, the empty option value splits on commas.  Values of other fields are
+// returned as they are.
+func splitValues(fi *FieldInfo, values []string) []string {
+	sep, ok := fi.Options["split"]
+	if !ok || Deref(fi.Field.Type).Kind() != reflect.Slice {
+		return values
+	}
+	if sep == "" {
+		sep = ","
+	}
+	var parts []string
+	for _, value := range values {
+		parts = append(parts, strings.Split(value, sep)...)
+	}
+	return parts
+}
+
+// SetMapEntry finds a map field by its mapped name and sets the element with
+// the key to value parsed according to the kind of the map elements like in
+// SetFieldString, ex. for form parameter "meta[color]=red" name is "meta" and
+// key is "color".  The map's key kind must be String.  For slice elements the
+// value is appended to the existing element.  Nil maps and nil pointers on
+// the way to the field are allocated, root must be addressable.  Panics if
+// root's Kind is not Struct or root is not Indirectable to a struct Kind.
+func (m *Mapper) SetMapEntry(root reflect.Value, name, key, value string) error {
+	root = allocIndirect(root)
+	mustBe(root, reflect.Struct)
+
+	fi, ok := m.TypeMap(root.Type()).lookup(name)
+	if !ok {
+		return &FieldNotFoundError{Name: name, Type: root.Type()}
+	}
+	f, err := fi.SettableValue(root)
+	if err != nil {
+		return err
+	}
+	if err := setMapEntry(f, key, value, m.parsers()); err != nil {
+		return fieldError(name, err)
+	}
+	return nil
+}
+
+// setMapEntry sets the element with the key of the map v to value using
+// setString, nil pointers and a nil map are allocated.
+func setMapEntry(v reflect.Value, key, value string, p parsers) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("cannot set key %s of %s", key, v.Type())
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+
+	k := reflect.ValueOf(key).Convert(v.Type().Key())
+	e := reflect.New(v.Type().Elem()).Elem()
+	if old := v.MapIndex(k); old.IsValid() {
+		e.Set(old)
+	}
+	if err := setString(e, value, p); err != nil {
+		return err
+	}
+	v.SetMapIndex(k, e)
+	return nil
+}
+
+// splitMapKey splits a name with a bracketed key suffix ex. "meta[color]"
+// into the base name and the key.
+func splitMapKey(name string) (base, key string, ok bool) {
+	i := strings.IndexByte(name, '[')
+	if i <= 0 || !strings.HasSuffix(name, "]") {
+		return "", "", false
+	}
+	return name[:i], name[i+1 : len(name)-1], true
+}
+
+// BindLayered sets the fields of root to the values of the layers in order,
+// ex. defaults followed by a config file followed by flags, so that values of
+// later layers override values of earlier layers.  The keys are mapped names
+// or names with a bracketed key suffix like in SetFieldStrings, the values are
+// parsed like in SetFieldString except that a value of a slice field replaces
+// the value from the earlier layers instead of being appended to it.  Keys
+// that are not found are ignored, use BindLayeredStrict to report them.  It
+// stops on the first error, the error starts with the key.  Nil pointers on
+// the way to the fields are allocated, root must be addressable.  Panics if
+// root's Kind is not Struct or root is not Indirectable to a struct Kind.
+func (m *Mapper) BindLayered(root reflect.Value, layers ...map[string]string) error {
+	_, err := m.bindLayers(root, layers)
+	return err
+}
+
+// BindLayeredStrict is like BindLayered but it also returns the keys that are
+// not found, in sorted order, and an error if there are any.  All the layers
+// are applied before the keys are reported.
+func (m *Mapper) BindLayeredStrict(root reflect.Value, layers ...map[string]string) ([]string, error) {
+	unknown, err := m.bindLayers(root, layers)
+	if err != nil {
+		return unknown, err
+	}
+	if len(unknown) != 0 {
+		return unknown, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil, nil
+}
+
+// bindLayers applies the layers to root and returns the sorted keys that are
+// not found.
+func (m *Mapper) bindLayers(root reflect.Value, layers []map[string]string) ([]string, error) {
+	root = allocIndirect(root)
+	mustBe(root, reflect.Struct)
+
+	tm := m.TypeMap(root.Type())
+	p := m.parsers()
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		keys := make([]string, 0, len(layer))
+		for k := range layer {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fi, ok := tm.lookup(k)
+			if !ok {
+				if base, key, ok := splitMapKey(k); ok {
+					if _, ok := tm.lookup(base); ok {
+						if err := m.SetMapEntry(root, base, key, layer[k]); err != nil {
+							return unknown, err
+						}
+						continue
+					}
+				}
+				if !seen[k] {
+					seen[k] = true
+					unknown = append(unknown, k)
+				}
+				continue
+			}
+			f, err := fi.SettableValue(root)
+			if err != nil {
+				return unknown, err
+			}
+			if Deref(fi.Field.Type).Kind() == reflect.Slice {
+				f.Set(reflect.Zero(f.Type()))
+			}
+			if err := setStrings(f, splitValues(fi, []string{layer[k]}), p); err != nil {
+				return unknown, fieldError(k, err)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// BindForm sets the fields of the struct pointed to by dest to the values of
+// form, ex. the parsed query or body of an HTTP request, like the names and
+// the values passed to SetFieldStrings, so slice fields get all the values of
+// a key and map fields can be set with keys like "meta[color]".  Keys that are
+// not found are ignored.  Nil pointers on the way to the fields, ex. embedded
+// struct pointers, are allocated.  It sets all the fields it can and returns
+// the errors of all the keys that fail joined with errors.Join, in the order
+// of the keys, every error starts with its key.  It returns an error if dest
+// is not a non-nil pointer to a struct.
+func (m *Mapper) BindForm(form url.Values, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("expected non-nil pointer to struct, got %T", dest)
+	}
+	if err := mustBeStruct(v.Elem()); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		err := m.SetFieldStrings(v, k, form[k])
+		if err == nil {
+			continue
+		}
+		var nf *FieldNotFoundError
+		if errors.As(err, &nf) {
+			continue
+		}
+		if !hasFieldName(err) {
+			err = fieldError(k, err)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Setter returns a function setting the field with the mapped name of structs
+// of type t like SetFieldString.  The traversal and the parsing function for
+// the kind of the field are resolved once, so it's faster than SetFieldString
+// when setting many values.  The setters are cached with the mapping of t.
+// Returns nil if the name is not found.  The returned function panics if
+// root's Kind is not Struct or root is not Indirectable to a struct Kind.
+func (m *Mapper) Setter(t reflect.Type, name string) func(root reflect.Value, value string) error {
+	tm := m.TypeMap(t)
+	if s, ok := tm.setters.Load(name); ok {
+		return s.(func(reflect.Value, string) error)
+	}
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return nil
+	}
+
+	set := compileSetter(fi.Field.Type, m.parsers())
+	setter := func(root reflect.Value, value string) error {
+		root = allocIndirect(root)
+		mustBe(root, reflect.Struct)
+
+		f, err := fi.SettableValue(root)
+		if err != nil {
+			return err
+		}
+		for _, value := range splitValues(fi, []string{value}) {
+			if err := set(f, value); err != nil {
+				return fieldError(name, err)
+			}
+		}
+		return nil
+	}
+	s, _ := tm.setters.LoadOrStore(name, setter)
+	return s.(func(reflect.Value, string) error)
+}
+
+// compileSetter returns a function setting values of type t like setStrings
+// with a single value, the kind switch is done once for basic kinds and
+// pointers and slices of them, other types fall back to setStrings.
+func compileSetter(t reflect.Type, p parsers) func(v reflect.Value, value string) error {
+	single := func(v reflect.Value, value string) error {
+		return setStrings(v, []string{value}, p)
+	}
+	if fn, ok := p.setters[t]; ok {
+		return fn
+	}
+	if labels, ok := p.enums[t]; ok {
+		return func(v reflect.Value, value string) error {
+			return setEnum(v, value, labels)
+		}
+	}
+	if t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return single
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := compileSetter(t.Elem(), p)
+		return func(v reflect.Value, value string) error {
+			if !v.IsNil() {
+				return elem(v.Elem(), value)
+			}
+			// a nil pointer is set only if the value is parsed
+			e := reflect.New(t.Elem())
+			if err := elem(e.Elem(), value); err != nil {
+				return err
+			}
+			v.Set(e)
+			return nil
+		}
+	case reflect.Slice:
+		elem := compileSetter(t.Elem(), p)
+		return func(v reflect.Value, value string) error {
+			e := reflect.New(t.Elem()).Elem()
+			if err := elem(e, value); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, e))
+			return nil
+		}
+	case reflect.String:
+		return func(v reflect.Value, value string) error {
+			v.SetString(value)
+			return nil
+		}
+	case reflect.Bool:
+		return func(v reflect.Value, value string) error {
+			b, err := p.parseBool(value)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			i, err := strconv.ParseInt(value, 10, bits)
+			if err != nil {
+				return err
+			}
+			v.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			u, err := strconv.ParseUint(value, 10, bits)
+			if err != nil {
+				return err
+			}
+			v.SetUint(u)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(v reflect.Value, value string) error {
+			f, err := strconv.ParseFloat(value, bits)
+			if err != nil {
+				return err
+			}
+			v.SetFloat(f)
+			return nil
+		}
+	}
+	return single
+}
+
+// enumLabels are the labels of the registered enum types.
+type enumLabels map[reflect.Type]map[string]int64
+
+// RegisterEnum registers the labels of the integer type t, ex. a type Status
+// int with named constants, the string setters SetFieldString,
+// SetFieldStrings, Setter and ApplyDefaults set fields of type t to the value
+// of the label, ex. "active" to StatusActive.  Labels that are not registered
+// are an error.  Registering a type again replaces its labels.  Panics if t's
+// Kind is not an integer Kind.
+func (m *Mapper) RegisterEnum(t reflect.Type, labels map[string]int64) {
+	if !isNumber(t.Kind()) || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+		panic(fmt.Errorf("%s is not an integer type", t))
+	}
+
+	l := make(map[string]int64, len(labels))
+	for k, v := range labels {
+		l[k] = v
+	}
+	// the setters returned by Setter are compiled with the labels, replace
+	// the registry so that the ones in use are not changed and clear the
+	// cache
+	m.update(func(o *mapOptions) {
+		enums := make(enumLabels, len(o.enums)+1)
+		for k, v := range o.enums {
+			enums[k] = v
+		}
+		enums[t] = l
+		o.enums = enums
+	})
+}
+
+// SetterFunc sets dst to the value parsed from raw, see RegisterSetter.
+type SetterFunc func(dst reflect.Value, raw string) error
+
+// RegisterSetter registers fn as the parser of values of type t, ex. net.IP,
+// the string setters SetFieldString, SetFieldStrings, SetMapEntry, Setter and
+// ApplyDefaults call fn for fields of type t, and for pointers, slices and
+// arrays of t, instead of parsing the value according to the field's kind.
+// The registered setters take precedence over enums and types implementing
+// encoding.TextUnmarshaler.  dst is settable.  Registering a type again
+// replaces its setter.  Changing the setting clears the cache.
+func (m *Mapper) RegisterSetter(t reflect.Type, fn SetterFunc) {
+	m.update(func(o *mapOptions) {
+		setters := make(map[reflect.Type]SetterFunc, len(o.setters)+1)
+		for k, v := range o.setters {
+			setters[k] = v
+		}
+		setters[t] = fn
+		o.setters = setters
+	})
+}
+
+// SetBoolStrings sets the strings that the string setters SetFieldString,
+// SetFieldStrings, SetMapEntry, Setter and ApplyDefaults parse as true and
+// false for bool fields, ex. "on" and "off" for HTML checkboxes.  The strings
+// are matched case-insensitively, values that do not match any of them are
+// parsed with strconv.ParseBool, which is the only parser by default.  If a
+// string is in both lists it's true.  Calling it with no strings restores the
+// default.  Changing the setting clears the cache.
+func (m *Mapper) SetBoolStrings(trueStrings, falseStrings []string) {
+	var bools map[string]bool
+	if len(trueStrings)+len(falseStrings) != 0 {
+		bools = make(map[string]bool, len(trueStrings)+len(falseStrings))
+		for _, s := range falseStrings {
+			bools[strings.ToLower(s)] = false
+		}
+		for _, s := range trueStrings {
+			bools[strings.ToLower(s)] = true
+		}
+	}
+	m.update(func(o *mapOptions) {
+		o.bools = bools
+	})
+}
+
+// parsers are the registered enum types and setters and the bool strings used
+// by the string setters, the maps must not be modified.
+type parsers struct {
+	enums   enumLabels
+	setters map[reflect.Type]SetterFunc
+	bools   map[string]bool
+}
+
+// parsers returns the registered enum types and setters and the bool strings.
+func (m *Mapper) parsers() parsers {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return parsers{enums: m.opts.enums, setters: m.opts.setters, bools: m.opts.bools}
+}
+
+// parseBool parses value using the bool strings with a fallback to
+// strconv.ParseBool.
+func (p parsers) parseBool(value string) (bool, error) {
+	if len(p.bools) != 0 {
+		if b, ok := p.bools[strings.ToLower(value)]; ok {
+			return b, nil
+		}
+	}
+	return strconv.ParseBool(value)
+}
+
+// setEnum sets the integer v to the value of the label.
+func setEnum(v reflect.Value, label string, labels map[string]int64) error {
+	n, ok := labels[label]
+	if !ok {
+		return fmt.Errorf("unknown %s label %q", v.Type(), label)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%s label %q value %d overflows %s", v.Type(), label, n, v.Kind())
+		}
+		v.SetInt(n)
+	default:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%s label %q value %d overflows %s", v.Type(), label, n, v.Kind())
+		}
+		v.SetUint(uint64(n))
+	}
+	return nil
+}
+
+// SetFieldValue finds a field by its mapped name and sets it to value.  The
+// value must be assignable to the field, numbers are converted to the field's
+// numeric type if the conversion does not lose information, ex. a float64
+// decoded from JSON can be set to an int field if it has no fractional part.
+// If the field is a pointer the value may be assignable to the pointed to
+// type.  A nil value sets the field to nil, it's allowed for pointers,
+// interfaces, maps, slices, channels and funcs.  If the value can not be
+// assigned a *FieldTypeError is returned.  Nil pointers on the way to the
+// field are allocated, v must be addressable.  Panics if v's Kind is not
+// Struct or v is not Indirectable to a struct Kind.
+func (m *Mapper) SetFieldValue(v reflect.Value, name string, value interface{}) error {
+	v = allocIndirect(v)
+	mustBe(v, reflect.Struct)
+
+	fi, ok := m.TypeMap(v.Type()).lookup(name)
+	if !ok {
+		return &FieldNotFoundError{Name: name, Type: v.Type()}
+	}
+	f, err := fi.SettableValue(v)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		switch f.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+		return &FieldTypeError{Name: name, Type: f.Type()}
+	}
+
+	val := reflect.ValueOf(value)
+	if x, ok := assignable(val, f.Type()); ok {
+		f.Set(x)
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		if x, ok := assignable(val, f.Type().Elem()); ok {
+			f.Elem().Set(x)
+			return nil
+		}
+	}
+	return &FieldTypeError{Name: name, Type: f.Type(), Value: val.Type()}
+}
+
+// assignable returns v as a value assignable to t, numbers are converted if
+// the conversion is lossless.
+func assignable(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Type().AssignableTo(t) {
+		return v, true
+	}
+	if !isNumber(v.Kind()) || !isNumber(t.Kind()) {
+		return reflect.Value{}, false
+	}
+	x := v.Convert(t)
+	if x.Convert(v.Type()).Interface() != v.Interface() {
+		return reflect.Value{}, false
+	}
+	return x, true
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// FieldTypeError is returned when a value can not be assigned to a field.
+type FieldTypeError struct {
+	Name string
+	// Type is the type of the field.
+	Type reflect.Type
+	// Value is the type of the value, it's nil for a nil value.
+	Value reflect.Type
+}
+
+func (e *FieldTypeError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("%s: cannot assign nil to %s", e.Name, e.Type)
+	}
+	return fmt.Sprintf("%s: cannot assign %s to %s", e.Name, e.Value, e.Type)
+}
+
+// UnsupportedKindError is returned by the string setters SetFieldString,
+// SetFieldStrings, Setter and ApplyDefaults when the kind of a field is not
+// supported, ex. for a complex64 field, use errors.As to check for it.
+type UnsupportedKindError struct {
+	Kind reflect.Kind
+	// Type is the type that is not supported, for pointers and slices it's
+	// the type of the elements.
+	Type reflect.Type
+	// Field is the mapped name of the field.
+	Field string
+}
+
+func (e *UnsupportedKindError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("unsupported kind %s", e.Type)
+	}
+	return fmt.Sprintf("%s: unsupported kind %s", e.Field, e.Type)
+}
+
+// fieldError returns err prefixed with the mapped name of the field, an
+// *UnsupportedKindError is returned with Field set.  The returned error wraps
+// err, ex. an error of a registered setter, so that callers can use
+// errors.Is and errors.As.
+func fieldError(name string, err error) error {
+	if e, ok := err.(*UnsupportedKindError); ok {
+		e.Field = name
+		return e
+	}
+	return &namedError{name: name, err: err}
+}
+
+// namedError is an error of the field with the mapped name, see fieldError.
+type namedError struct {
+	name string
+	err  error
+}
+
+func (e *namedError) Error() string {
+	return e.name + ": " + e.err.Error()
+}
+
+func (e *namedError) Unwrap() error {
+	return e.err
+}
+
+// hasFieldName reports whether err is prefixed with the mapped name of a
+// field by fieldError.
+func hasFieldName(err error) bool {
+	var ne *namedError
+	if errors.As(err, &ne) {
+		return true
+	}
+	var uk *UnsupportedKindError
+	return errors.As(err, &uk) && uk.Field != ""
+}
+
+// ApplyDefaults sets the fields of v that are zero values to the value of
+// their "default" tag option, ex. `http:"max,default=10"`.  Values are parsed
+// like in SetFieldString, for slices the value is split on commas and every
+// part is appended, commas in tags need to be escaped or quoted ex.
+// `http:"l,default=foo\\,bar"` or `http:"l,default='foo,bar'"`.  Fields
+// without the option are not changed.  It stops on the first error, the
+// error starts with the field's mapped name.  Panics if v's Kind is not
+// Struct or v is not Indirectable to a struct Kind.
+func (m *Mapper) ApplyDefaults(v reflect.Value) error {
+	v = allocIndirect(v)
+	mustBe(v, reflect.Struct)
+
+	var err error
+	p := m.parsers()
+	tm := m.TypeMap(v.Type())
+	tm.Walk(func(fi *FieldInfo) bool {
+		def, ok := fi.Options["default"]
+		if !ok || tm.Names[fi.Path] != fi || !fi.IsZero(v) {
+			return true
+		}
+		err = applyDefault(v, fi, def, p)
+		return err == nil
+	})
+	return err
+}
+
+func applyDefault(v reflect.Value, fi *FieldInfo, def string, p parsers) error {
+	f, err := fi.SettableValue(v)
+	if err != nil {
+		return err
+	}
+
+	values := []string{def}
+	if k := indirect(f).Kind(); k == reflect.Slice || k == reflect.Array {
+		values = strings.Split(def, ",")
+	}
+	if err := setStrings(f, values, p); err != nil {
+		return fieldError(fi.Path, err)
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isTextUnmarshaler reports whether v implements encoding.TextUnmarshaler
+// with a value or pointer receiver.
+func isTextUnmarshaler(v reflect.Value) bool {
+	return v.Type().Implements(textUnmarshalerType) ||
+		v.CanAddr() && reflect.PtrTo(v.Type()).Implements(textUnmarshalerType)
+}
+
+// setStrings sets v to the values using setString, the elements of an array
+// are set to the consecutive values, other kinds are set to every value in
+// turn.
+func setStrings(v reflect.Value, values []string, p parsers) error {
+	if v.Kind() == reflect.Ptr && !isTextUnmarshaler(v) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setStrings(v.Elem(), values, p)
+	}
+
+	if v.Kind() == reflect.Array && !isTextUnmarshaler(v) {
+		if len(values) > v.Len() {
+			return fmt.Errorf("too many values for %s: %d", v.Type(), len(values))
+		}
+		for i, value := range values {
+			if err := setString(v.Index(i), value, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, value := range values {
+		if err := setString(v, value, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setString unmarshals string representation of a basic kind into v using
+// a "kind switch", types implementing encoding.TextUnmarshaler with a value
+// or pointer receiver unmarshal themselves, registered enum types are set to
+// the value of the label and types with registered setters are set by them.
+func setString(v reflect.Value, value string, p parsers) error {
+	if fn, ok := p.setters[v.Type()]; ok {
+		return fn(v, value)
+	}
+	if labels, ok := p.enums[v.Type()]; ok {
+		return setEnum(v, value, labels)
+	}
+	if v.Type().Implements(textUnmarshalerType) {
+		switch {
+		case v.Kind() == reflect.Interface && v.IsNil():
+			// there is no concrete type to unmarshal to
+			return fmt.Errorf("cannot unmarshal into nil %s", v.Type())
+		case v.Kind() == reflect.Ptr && v.IsNil():
+			v.Set(reflect.New(v.Type().Elem()))
+		case v.Kind() == reflect.Map && v.IsNil():
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		// a nil pointer is set only if the value is parsed, an empty value
+		// is parsed too, ex. for *string it's a pointer to an empty string
+		if !v.IsNil() {
+			return setString(v.Elem(), value, p)
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := setString(elem.Elem(), value, p); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice:
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := setString(elem, value, p); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+	case reflect.Interface:
+		// only the empty interface can hold a string
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("cannot set %s to a string", v.Type())
+		}
+		v.Set(reflect.ValueOf(value))
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := p.parseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return &UnsupportedKindError{Kind: v.Kind(), Type: v.Type()}
+	}
+	return nil
+}
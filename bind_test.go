@@ -0,0 +1,1070 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetFieldString(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid"`
+	}
+	type Request struct {
+		*Context
+		S   string    `http:"s"`
+		B   bool      `http:"b"`
+		I   int       `http:"i"`
+		I8  int8      `http:"i8"`
+		I16 int16     `http:"i16"`
+		I32 int32     `http:"i32"`
+		I64 int64     `http:"i64"`
+		U   uint      `http:"u"`
+		U8  uint8     `http:"u8"`
+		U16 uint16    `http:"u16"`
+		U32 uint32    `http:"u32"`
+		U64 uint64    `http:"u64"`
+		F32 float32   `http:"f32"`
+		F64 float64   `http:"f64"`
+		P   *int      `http:"p"`
+		L   []string  `http:"l"`
+		LF  []float64 `http:"lf"`
+		C   complex64 `http:"c"`
+	}
+
+	m := NewMapper("http")
+	values := [][2]string{
+		{"sid", "id"},
+		{"s", "foo"},
+		{"b", "true"},
+		{"i", "-1"},
+		{"i8", "-8"},
+		{"i16", "-16"},
+		{"i32", "-32"},
+		{"i64", "-64"},
+		{"u", "1"},
+		{"u8", "8"},
+		{"u16", "16"},
+		{"u32", "32"},
+		{"u64", "64"},
+		{"f32", "0.5"},
+		{"f64", "1.5"},
+		{"p", "7"},
+		{"l", "foo"},
+		{"l", "bar"},
+		{"lf", "1"},
+		{"lf", "2"},
+	}
+
+	var r Request
+	for _, kv := range values {
+		if err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := 7
+	expected := Request{
+		Context: &Context{SessionID: "id"},
+		S:       "foo",
+		B:       true,
+		I:       -1,
+		I8:      -8,
+		I16:     -16,
+		I32:     -32,
+		I64:     -64,
+		U:       1,
+		U8:      8,
+		U16:     16,
+		U32:     32,
+		U64:     64,
+		F32:     0.5,
+		F64:     1.5,
+		P:       &p,
+		L:       []string{"foo", "bar"},
+		LF:      []float64{1, 2},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	errors := []struct {
+		name  string
+		value string
+	}{
+		{"i8", "128"},
+		{"u", "-1"},
+		{"b", "foo"},
+		{"lf", "foo"},
+		{"c", "1"},
+		{"missing", "1"},
+	}
+	for _, e := range errors {
+		err := m.SetFieldString(reflect.ValueOf(&r), e.name, e.value)
+		if err == nil {
+			t.Errorf("Expecting error for %s=%s", e.name, e.value)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), e.name+": ") {
+			t.Errorf("Expecting error to include field name %s, got %s", e.name, err)
+		}
+	}
+
+	if err := m.SetFieldString(reflect.ValueOf(Request{}), "s", "foo"); err == nil {
+		t.Error("Expecting error for not addressable value")
+	}
+}
+
+func TestSetFieldStringPointer(t *testing.T) {
+	type Request struct {
+		Max    *int    `http:"max"`
+		Exact  *bool   `http:"x"`
+		Query  *string `http:"q"`
+		Title  *string `http:"title"`
+		Offset *int    `http:"offset"`
+	}
+
+	m := NewMapper("http")
+	values := [][2]string{
+		{"max", "10"},
+		{"x", "true"},
+		{"q", ""},
+	}
+
+	var r Request
+	for _, kv := range values {
+		if err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.Max == nil || *r.Max != 10 {
+		t.Errorf("Expecting 10, got %v", r.Max)
+	}
+	if r.Exact == nil || !*r.Exact {
+		t.Errorf("Expecting true, got %v", r.Exact)
+	}
+	if r.Query == nil || *r.Query != "" {
+		t.Errorf("Expecting pointer to empty string, got %v", r.Query)
+	}
+	if r.Title != nil {
+		t.Errorf("Expecting nil, got %v", r.Title)
+	}
+
+	if err := m.SetFieldString(reflect.ValueOf(&r), "offset", "foo"); err == nil {
+		t.Error("Expecting error for invalid int")
+	}
+}
+
+func TestSetFieldStringInterface(t *testing.T) {
+	type Request struct {
+		Extra   interface{}   `http:"extra"`
+		Values  []interface{} `http:"values"`
+		Ptr     *interface{}  `http:"ptr"`
+		Printer fmt.Stringer  `http:"printer"`
+	}
+
+	m := NewMapper("http")
+	var r Request
+	values := [][2]string{
+		{"extra", "foo"},
+		{"values", "a"},
+		{"values", "1"},
+		{"ptr", "bar"},
+	}
+	for _, kv := range values {
+		if err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ptr interface{} = "bar"
+	expected := Request{Extra: "foo", Values: []interface{}{"a", "1"}, Ptr: &ptr}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	var s Request
+	if err := m.Setter(reflect.TypeOf(s), "extra")(reflect.ValueOf(&s), "foo"); err != nil || s.Extra != "foo" {
+		t.Errorf("Expecting %s, got %v %v", "foo", s.Extra, err)
+	}
+
+	err := m.SetFieldString(reflect.ValueOf(&r), "printer", "foo")
+	if err == nil || !strings.HasPrefix(err.Error(), "printer:") {
+		t.Errorf("Expecting error for printer, got %v", err)
+	}
+}
+
+func TestSetFieldStrings(t *testing.T) {
+	type Request struct {
+		Dims  [3]int    `http:"dim"`
+		IP    *[4]byte  `http:"ip"`
+		Tags  []string  `http:"tag"`
+		Max   int       `http:"max"`
+		Until [2]string `http:"until,default=a\\,b"`
+	}
+
+	m := NewMapper("http")
+	var r Request
+	values := map[string][]string{
+		"dim": {"1", "2", "3"},
+		"ip":  {"127", "0", "0", "1"},
+		"tag": {"a", "b"},
+		"max": {"1", "2"},
+	}
+	for name, v := range values {
+		if err := m.SetFieldStrings(reflect.ValueOf(&r), name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ApplyDefaults(reflect.ValueOf(&r)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Request{
+		Dims:  [3]int{1, 2, 3},
+		IP:    &[4]byte{127, 0, 0, 1},
+		Tags:  []string{"a", "b"},
+		Max:   2,
+		Until: [2]string{"a", "b"},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	err := m.SetFieldStrings(reflect.ValueOf(&r), "dim", []string{"1", "2", "3", "4"})
+	if err == nil {
+		t.Fatal("Expecting error for too many values")
+	}
+	if !strings.HasPrefix(err.Error(), "dim: ") {
+		t.Errorf("Expecting error to include field name %s, got %s", "dim", err)
+	}
+
+	if err := m.SetFieldString(reflect.ValueOf(&r), "dim", "7"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Dims != [3]int{7, 2, 3} {
+		t.Errorf("Expecting first element to be set, got %v", r.Dims)
+	}
+}
+
+type timeout struct {
+	d time.Duration
+}
+
+func (t *timeout) UnmarshalText(text []byte) error {
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	t.d = d
+	return nil
+}
+
+type upper map[string]bool
+
+func (u upper) UnmarshalText(text []byte) error {
+	u[strings.ToUpper(string(text))] = true
+	return nil
+}
+
+func TestSplitOption(t *testing.T) {
+	type Request struct {
+		L []string  `http:"l"`
+		S []string  `http:"s,split"`
+		C []string  `http:"c,split=;"`
+		P *[]int    `http:"p,split"`
+		N string    `http:"n,split"`
+		A [2]string `http:"a,split"`
+	}
+
+	m := NewMapper("http")
+	values := [][2]string{
+		{"l", "a,b"},
+		{"l", "c"},
+		{"s", "a,b"},
+		{"s", "c"},
+		{"c", "a,b;c"},
+		{"p", "1,2"},
+		{"n", "a,b"},
+		{"a", "a,b"},
+	}
+	expected := Request{
+		L: []string{"a,b", "c"},
+		S: []string{"a", "b", "c"},
+		C: []string{"a,b", "c"},
+		P: &[]int{1, 2},
+		N: "a,b",
+		A: [2]string{"a,b"},
+	}
+
+	var r, s Request
+	for _, kv := range values {
+		if err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Setter(reflect.TypeOf(s), kv[0])(reflect.ValueOf(&s), kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, s)
+	}
+
+	r = Request{}
+	if err := m.SetFieldStrings(reflect.ValueOf(&r), "s", []string{"a,b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if e := []string{"a", "b", "c"}; !reflect.DeepEqual(r.S, e) {
+		t.Errorf("Expecting %v, got %v", e, r.S)
+	}
+	if err := m.SetFieldString(reflect.ValueOf(&r), "p", "1,x"); err == nil || !strings.HasPrefix(err.Error(), "p:") {
+		t.Errorf("Expecting error for p, got %v", err)
+	}
+}
+
+func TestSetMapEntry(t *testing.T) {
+	type Key string
+	type Request struct {
+		Meta   map[string]string    `http:"meta"`
+		Counts map[Key]int          `http:"counts"`
+		Tags   *map[string][]string `http:"tags"`
+		Times  map[string]time.Time `http:"times"`
+		Dims   map[int]int          `http:"dims"`
+		Max    int                  `http:"max"`
+	}
+
+	m := NewMapper("http")
+	var r Request
+	v := reflect.ValueOf(&r)
+	entries := [][3]string{
+		{"meta", "color", "red"},
+		{"meta", "size", "10"},
+		{"counts", "a", "1"},
+		{"tags", "a", "x"},
+		{"tags", "a", "y"},
+		{"times", "t", "2019-01-02T15:04:05Z"},
+	}
+	for _, e := range entries {
+		if err := m.SetMapEntry(v, e[0], e[1], e[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetFieldStrings(v, "counts[b]", []string{"2", "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := map[string][]string{"a": {"x", "y"}}
+	expected := Request{
+		Meta:   map[string]string{"color": "red", "size": "10"},
+		Counts: map[Key]int{"a": 1, "b": 3},
+		Tags:   &tags,
+		Times:  map[string]time.Time{"t": time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	errs := [][3]string{
+		{"counts", "c", "x"},
+		{"dims", "a", "1"},
+		{"max", "a", "1"},
+		{"missing", "a", "1"},
+	}
+	for _, e := range errs {
+		err := m.SetMapEntry(v, e[0], e[1], e[2])
+		if err == nil {
+			t.Errorf("Expecting error for %s[%s]", e[0], e[1])
+		}
+	}
+	if err := m.SetFieldString(v, "counts[c]", "x"); err == nil || !strings.HasPrefix(err.Error(), "counts:") {
+		t.Errorf("Expecting error for counts, got %v", err)
+	}
+	if err := m.SetFieldString(v, "[a]", "1"); err == nil {
+		t.Error("Expecting error for empty base name")
+	}
+}
+
+func TestBindLayered(t *testing.T) {
+	type Limits struct {
+		Max int `cfg:"max"`
+	}
+	type Config struct {
+		*Limits
+		Host  string            `cfg:"host"`
+		Port  int               `cfg:"port"`
+		Debug bool              `cfg:"debug"`
+		Tags  []string          `cfg:"tags,split"`
+		Meta  map[string]string `cfg:"meta"`
+	}
+
+	m := NewMapper("cfg")
+	defaults := map[string]string{
+		"host":       "localhost",
+		"port":       "9042",
+		"max":        "10",
+		"tags":       "a,b",
+		"meta[zone]": "a",
+		"timeout":    "1s",
+	}
+	overrides := map[string]string{
+		"port":       "9142",
+		"debug":      "true",
+		"tags":       "c",
+		"meta[zone]": "b",
+		"retries":    "3",
+	}
+
+	var c Config
+	if err := m.BindLayered(reflect.ValueOf(&c), defaults, overrides); err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{
+		Limits: &Limits{Max: 10},
+		Host:   "localhost",
+		Port:   9142,
+		Debug:  true,
+		Tags:   []string{"c"},
+		Meta:   map[string]string{"zone": "b"},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, c)
+	}
+
+	c = Config{}
+	unknown, err := m.BindLayeredStrict(reflect.ValueOf(&c), defaults, overrides)
+	if err == nil {
+		t.Error("Expecting error")
+	}
+	if e := []string{"retries", "timeout"}; !reflect.DeepEqual(unknown, e) {
+		t.Errorf("Expecting %v, got %v", e, unknown)
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, c)
+	}
+
+	if err := m.BindLayered(reflect.ValueOf(&c), map[string]string{"port": "x"}); err == nil || !strings.HasPrefix(err.Error(), "port") {
+		t.Errorf("Expecting port error, got %v", err)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid"`
+	}
+	type Request struct {
+		*Context
+		Labels []string          `http:"l"`
+		Max    int               `http:"max"`
+		Exact  bool              `http:"x"`
+		Meta   map[string]string `http:"meta"`
+		Min    *int              `http:"min"`
+	}
+
+	m := NewMapper("http")
+	form := url.Values{
+		"sid":        {"id"},
+		"l":          {"foo", "bar"},
+		"max":        {"100"},
+		"x":          {"true"},
+		"meta[zone]": {"a"},
+		"other":      {"1"},
+		"other[k]":   {"1"},
+	}
+	var r Request
+	if err := m.BindForm(form, &r); err != nil {
+		t.Fatal(err)
+	}
+	expected := Request{
+		Context: &Context{SessionID: "id"},
+		Labels:  []string{"foo", "bar"},
+		Max:     100,
+		Exact:   true,
+		Meta:    map[string]string{"zone": "a"},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	r = Request{}
+	form = url.Values{
+		"sid": {"id"},
+		"max": {"x"},
+		"min": {"1.5"},
+		"x":   {"y"},
+	}
+	err := m.BindForm(form, &r)
+	if err == nil {
+		t.Fatal("Expecting error")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expecting %d errors, got %q", 3, lines)
+	}
+	for i, k := range []string{"max", "min", "x"} {
+		if !strings.HasPrefix(lines[i], k+":") {
+			t.Errorf("Expecting error for %s, got %s", k, lines[i])
+		}
+	}
+	if r.Context == nil || r.SessionID != "id" {
+		t.Errorf("Expecting other fields to be set, got %+v", r)
+	}
+
+	for _, dest := range []interface{}{r, (*Request)(nil), new(int), nil} {
+		if err := m.BindForm(form, dest); err == nil {
+			t.Errorf("Expecting error for %T", dest)
+		}
+	}
+}
+
+func TestSetFieldStringTextUnmarshaler(t *testing.T) {
+	type Request struct {
+		Timeout  timeout   `http:"timeout"`
+		Deadline *timeout  `http:"deadline"`
+		Retries  []timeout `http:"retry"`
+		Tags     upper     `http:"tag"`
+	}
+
+	m := NewMapper("http")
+	values := [][2]string{
+		{"timeout", "1s"},
+		{"deadline", "1m"},
+		{"retry", "1ms"},
+		{"retry", "2ms"},
+		{"tag", "foo"},
+	}
+
+	var r Request
+	for _, kv := range values {
+		if err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := Request{
+		Timeout:  timeout{time.Second},
+		Deadline: &timeout{time.Minute},
+		Retries:  []timeout{{time.Millisecond}, {2 * time.Millisecond}},
+		Tags:     upper{"FOO": true},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	if err := m.SetFieldString(reflect.ValueOf(&r), "timeout", "foo"); err == nil {
+		t.Error("Expecting error for invalid duration")
+	}
+
+	var u struct {
+		U encoding.TextUnmarshaler `http:"u"`
+	}
+	if err := m.SetFieldString(reflect.ValueOf(&u), "u", "1s"); err == nil {
+		t.Error("Expecting error for nil interface")
+	}
+	if err := m.Setter(reflect.TypeOf(u), "u")(reflect.ValueOf(&u), "1s"); err == nil {
+		t.Error("Expecting error for nil interface")
+	}
+	u.U = &timeout{}
+	if err := m.SetFieldString(reflect.ValueOf(&u), "u", "1s"); err != nil || *u.U.(*timeout) != (timeout{time.Second}) {
+		t.Errorf("Expecting %v, got %v %v", time.Second, u.U, err)
+	}
+}
+
+func TestSetter(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid"`
+	}
+	type Request struct {
+		*Context
+		S  string     `http:"s"`
+		B  bool       `http:"b"`
+		I8 int8       `http:"i8"`
+		U  uint       `http:"u"`
+		F  float32    `http:"f"`
+		P  *int       `http:"p"`
+		L  []int      `http:"l"`
+		A  [2]string  `http:"a"`
+		T  time.Time  `http:"t"`
+		PT *time.Time `http:"pt"`
+	}
+
+	m := NewMapper("http")
+	typ := reflect.TypeOf(Request{})
+	values := [][2]string{
+		{"sid", "id"},
+		{"s", "foo"},
+		{"b", "true"},
+		{"i8", "-8"},
+		{"u", "1"},
+		{"f", "0.5"},
+		{"p", "7"},
+		{"l", "1"},
+		{"l", "2"},
+		{"a", "foo"},
+		{"t", "2019-01-02T15:04:05Z"},
+		{"pt", "2019-01-02T15:04:05Z"},
+	}
+
+	var r, expected Request
+	for _, kv := range values {
+		set := m.Setter(typ, kv[0])
+		if set == nil {
+			t.Fatalf("Expecting setter for %s", kv[0])
+		}
+		if err := set(reflect.ValueOf(&r), kv[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SetFieldString(reflect.ValueOf(&expected), kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	if a, b := m.Setter(typ, "s"), m.Setter(typ, "s"); reflect.ValueOf(a).Pointer() != reflect.ValueOf(b).Pointer() {
+		t.Error("Expecting cached setter")
+	}
+	if set := m.Setter(typ, "missing"); set != nil {
+		t.Error("Expecting nil setter for missing name")
+	}
+
+	for _, name := range []string{"i8", "p", "l"} {
+		err := m.Setter(typ, name)(reflect.ValueOf(&r), "foo")
+		if err == nil || !strings.HasPrefix(err.Error(), name+": ") {
+			t.Errorf("Expecting error to include field name %s, got %v", name, err)
+		}
+	}
+}
+
+func BenchmarkSetFieldString(b *testing.B) {
+	type Request struct {
+		Max int `http:"max"`
+	}
+	m := NewMapper("http")
+	v := reflect.ValueOf(&Request{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.SetFieldString(v, "max", "10"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetter(b *testing.B) {
+	type Request struct {
+		Max int `http:"max"`
+	}
+	m := NewMapper("http")
+	v := reflect.ValueOf(&Request{})
+	set := m.Setter(v.Type(), "max")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := set(v, "10"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type status int
+
+const (
+	statusActive status = iota + 1
+	statusInactive
+)
+
+func TestRegisterEnum(t *testing.T) {
+	type Request struct {
+		Status  status   `http:"status"`
+		Ptr     *status  `http:"ptr"`
+		List    []status `http:"list"`
+		Default status   `http:"default,default=inactive"`
+		Small   int8     `http:"small"`
+	}
+
+	m := NewMapper("http")
+	typ := reflect.TypeOf(Request{})
+	var r Request
+	if err := m.SetFieldString(reflect.ValueOf(&r), "status", "active"); err == nil {
+		t.Error("Expecting error for not registered enum")
+	}
+
+	m.RegisterEnum(reflect.TypeOf(status(0)), map[string]int64{
+		"active":   int64(statusActive),
+		"inactive": int64(statusInactive),
+	})
+	m.RegisterEnum(reflect.TypeOf(int8(0)), map[string]int64{"big": 1000})
+
+	if err := m.SetFieldString(reflect.ValueOf(&r), "status", "active"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetFieldStrings(reflect.ValueOf(&r), "list", []string{"inactive", "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Setter(typ, "ptr")(reflect.ValueOf(&r), "inactive"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyDefaults(reflect.ValueOf(&r)); err != nil {
+		t.Fatal(err)
+	}
+
+	inactive := statusInactive
+	expected := Request{
+		Status:  statusActive,
+		Ptr:     &inactive,
+		List:    []status{statusInactive, statusActive},
+		Default: statusInactive,
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	for _, kv := range [][2]string{{"status", "unknown"}, {"small", "big"}} {
+		err := m.SetFieldString(reflect.ValueOf(&r), kv[0], kv[1])
+		if err == nil || !strings.HasPrefix(err.Error(), kv[0]+": ") {
+			t.Errorf("Expecting error to include field name %s, got %v", kv[0], err)
+		}
+	}
+	if err := m.Setter(typ, "status")(reflect.ValueOf(&r), "unknown"); err == nil {
+		t.Error("Expecting error for unknown label")
+	}
+}
+
+type point struct {
+	X, Y int
+}
+
+func parsePoint(dst reflect.Value, raw string) error {
+	var p point
+	if _, err := fmt.Sscanf(raw, "%d:%d", &p.X, &p.Y); err != nil {
+		return err
+	}
+	dst.Set(reflect.ValueOf(p))
+	return nil
+}
+
+func TestRegisterSetter(t *testing.T) {
+	type Request struct {
+		Point   point            `http:"point"`
+		Ptr     *point           `http:"ptr"`
+		List    []point          `http:"list"`
+		Default point            `http:"default,default=5:6"`
+		Meta    map[string]point `http:"meta"`
+	}
+
+	m := NewMapper("http")
+	typ := reflect.TypeOf(Request{})
+	var r Request
+	if err := m.SetFieldString(reflect.ValueOf(&r), "point", "1:2"); err == nil {
+		t.Error("Expecting error for not registered setter")
+	}
+
+	m.RegisterSetter(reflect.TypeOf(point{}), parsePoint)
+	v := reflect.ValueOf(&r)
+	if err := m.SetFieldString(v, "point", "1:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Setter(typ, "ptr")(v, "3:4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetFieldStrings(v, "list", []string{"1:1", "2:2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMapEntry(v, "meta", "a", "7:8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyDefaults(v); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Request{
+		Point:   point{1, 2},
+		Ptr:     &point{3, 4},
+		List:    []point{{1, 1}, {2, 2}},
+		Default: point{5, 6},
+		Meta:    map[string]point{"a": {7, 8}},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	err := m.SetFieldString(v, "point", "x")
+	if err == nil || !strings.HasPrefix(err.Error(), "point:") {
+		t.Errorf("Expecting error for point, got %v", err)
+	}
+	if err := m.Setter(typ, "point")(v, "x"); err == nil {
+		t.Error("Expecting error for invalid point")
+	}
+}
+
+func TestSetBoolStrings(t *testing.T) {
+	type Form struct {
+		Remember bool   `http:"remember"`
+		Notify   *bool  `http:"notify"`
+		Flags    []bool `http:"flags"`
+	}
+
+	m := NewMapper("http")
+	var f Form
+	v := reflect.ValueOf(&f)
+	if err := m.SetFieldString(v, "remember", "on"); err == nil {
+		t.Error("Expecting error by default")
+	}
+
+	m.SetBoolStrings([]string{"on", "yes", "y"}, []string{"off", "no", "n"})
+	values := map[string][]string{
+		"remember": {"on"},
+		"notify":   {"Off"},
+		"flags":    {"yes", "N", "1", "false"},
+	}
+	for name, vals := range values {
+		if err := m.SetFieldStrings(v, name, vals); err != nil {
+			t.Fatal(err)
+		}
+	}
+	no := false
+	expected := Form{Remember: true, Notify: &no, Flags: []bool{true, false, true, false}}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, f)
+	}
+
+	set := m.Setter(reflect.TypeOf(f), "remember")
+	if err := set(v, "off"); err != nil || f.Remember {
+		t.Errorf("Expecting false, got %v %v", f.Remember, err)
+	}
+	if err := set(v, "maybe"); err == nil {
+		t.Error("Expecting error")
+	}
+
+	m.SetBoolStrings(nil, nil)
+	if err := m.SetFieldString(v, "remember", "on"); err == nil {
+		t.Error("Expecting error after restoring the default")
+	}
+}
+
+func TestUnsupportedKindError(t *testing.T) {
+	type Request struct {
+		C  complex64   `http:"c"`
+		PC *complex128 `http:"pc"`
+		M  map[int]int `http:"m"`
+		D  complex64   `http:"d,default=1"`
+	}
+
+	m := NewMapper("http")
+	var r Request
+
+	tests := []struct {
+		name string
+		err  error
+		kind reflect.Kind
+	}{
+		{"c", m.SetFieldString(reflect.ValueOf(&r), "c", "1"), reflect.Complex64},
+		{"pc", m.SetFieldStrings(reflect.ValueOf(&r), "pc", []string{"1"}), reflect.Complex128},
+		{"m", m.Setter(reflect.TypeOf(r), "m")(reflect.ValueOf(&r), "1"), reflect.Map},
+		{"d", m.ApplyDefaults(reflect.ValueOf(&r)), reflect.Complex64},
+	}
+	for _, test := range tests {
+		var e *UnsupportedKindError
+		if !errors.As(test.err, &e) {
+			t.Errorf("Expecting UnsupportedKindError for %s, got %v", test.name, test.err)
+			continue
+		}
+		if e.Field != test.name || e.Kind != test.kind {
+			t.Errorf("Expecting %s %s, got %s %s", test.name, test.kind, e.Field, e.Kind)
+		}
+		if !strings.HasPrefix(e.Error(), test.name+": unsupported kind ") {
+			t.Errorf("Unexpected error message %s", e)
+		}
+	}
+
+	if err := m.SetFieldString(reflect.ValueOf(&r), "missing", "1"); errors.As(err, new(*UnsupportedKindError)) {
+		t.Errorf("Unexpected UnsupportedKindError %v", err)
+	}
+
+}
+
+func TestFieldErrorWrapping(t *testing.T) {
+	type ID string
+	type Request struct {
+		ID  ID  `http:"id"`
+		Max int `http:"max"`
+	}
+
+	errInvalid := errors.New("invalid id")
+	m := NewMapper("http")
+	m.RegisterSetter(reflect.TypeOf(ID("")), func(dst reflect.Value, raw string) error {
+		return errInvalid
+	})
+	var r Request
+	v := reflect.ValueOf(&r)
+
+	for _, err := range []error{
+		m.SetFieldString(v, "id", "x"),
+		m.Setter(reflect.TypeOf(r), "id")(v, "x"),
+		m.BindForm(url.Values{"id": {"x"}}, &r),
+	} {
+		if !errors.Is(err, errInvalid) || !strings.HasPrefix(err.Error(), "id: ") {
+			t.Errorf("Expecting wrapped %v, got %v", errInvalid, err)
+		}
+	}
+	var ne *strconv.NumError
+	if err := m.SetFieldString(v, "max", "x"); !errors.As(err, &ne) {
+		t.Errorf("Expecting *strconv.NumError, got %v", err)
+	}
+	if err := m.BindForm(url.Values{"max": {"x"}}, &r); err == nil || strings.Count(err.Error(), "max: ") != 1 {
+		t.Errorf("Expecting error prefixed with the name once, got %v", err)
+	}
+}
+
+func TestSetFieldValue(t *testing.T) {
+	type Context struct {
+		SessionID string `json:"sid"`
+	}
+	type Request struct {
+		*Context
+		Max    int               `json:"max"`
+		Ratio  float32           `json:"ratio"`
+		Limit  *int              `json:"limit"`
+		Tags   []string          `json:"tags"`
+		Extra  map[string]string `json:"extra"`
+		Filter interface{}       `json:"filter"`
+	}
+
+	m := NewMapper("json")
+	values := []struct {
+		name  string
+		value interface{}
+	}{
+		{"sid", "id"},
+		{"max", float64(10)},
+		{"ratio", 0.5},
+		{"limit", float64(5)},
+		{"tags", []string{"a", "b"}},
+		{"extra", nil},
+		{"filter", map[string]interface{}{"a": 1}},
+	}
+
+	r := Request{Extra: map[string]string{"a": "b"}}
+	for _, kv := range values {
+		if err := m.SetFieldValue(reflect.ValueOf(&r), kv.name, kv.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := 5
+	expected := Request{
+		Context: &Context{SessionID: "id"},
+		Max:     10,
+		Ratio:   0.5,
+		Limit:   &l,
+		Tags:    []string{"a", "b"},
+		Filter:  map[string]interface{}{"a": 1},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	if err := m.SetFieldValue(reflect.ValueOf(&r), "limit", nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.Limit != nil {
+		t.Errorf("Expecting nil, got %v", r.Limit)
+	}
+
+	errors := []struct {
+		name  string
+		value interface{}
+	}{
+		{"max", 1.5},
+		{"max", "10"},
+		{"max", nil},
+		{"tags", []interface{}{"a"}},
+		{"sid", 1},
+	}
+	for _, e := range errors {
+		err := m.SetFieldValue(reflect.ValueOf(&r), e.name, e.value)
+		if _, ok := err.(*FieldTypeError); !ok {
+			t.Errorf("Expecting *FieldTypeError for %s=%v, got %v", e.name, e.value, err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), e.name+": ") {
+			t.Errorf("Expecting error to include field name %s, got %s", e.name, err)
+		}
+	}
+
+	if _, ok := m.SetFieldValue(reflect.ValueOf(&r), "missing", 1).(*FieldNotFoundError); !ok {
+		t.Error("Expecting *FieldNotFoundError")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid,default=none"`
+	}
+	type Request struct {
+		*Context
+		Labels  []string `http:"l,default=foo\\,bar"`
+		Max     int      `http:"max,default=10"`
+		Exact   bool     `http:"x,default=true"`
+		Timeout timeout  `http:"timeout,default=1s"`
+		Query   string   `http:"q"`
+	}
+
+	m := NewMapper("http")
+
+	var r Request
+	if err := m.ApplyDefaults(reflect.ValueOf(&r)); err != nil {
+		t.Fatal(err)
+	}
+	expected := Request{
+		Context: &Context{SessionID: "none"},
+		Labels:  []string{"foo", "bar"},
+		Max:     10,
+		Exact:   true,
+		Timeout: timeout{time.Second},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	r = Request{Context: &Context{SessionID: "id"}, Labels: []string{"baz"}, Max: 1, Query: "q"}
+	if err := m.ApplyDefaults(reflect.ValueOf(&r)); err != nil {
+		t.Fatal(err)
+	}
+	expected = Request{
+		Context: &Context{SessionID: "id"},
+		Labels:  []string{"baz"},
+		Max:     1,
+		Exact:   true,
+		Timeout: timeout{time.Second},
+		Query:   "q",
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	type Bad struct {
+		Exact bool `http:"x,default=maybe"`
+	}
+	err := m.ApplyDefaults(reflect.ValueOf(&Bad{}))
+	if err == nil {
+		t.Fatal("Expecting error")
+	}
+	if !strings.HasPrefix(err.Error(), "x: ") {
+		t.Errorf("Expecting error to include field name %s, got %s", "x", err)
+	}
+}
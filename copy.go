@@ -0,0 +1,136 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CopyMatchingFields copies the fields of src to the fields of dst mapped
+// with the same names and returns the number of copied fields, ex. to copy a
+// request to a model sharing tag names.  Only fields without children are
+// copied, fields of nested structs are copied one by one.  Names mapped in
+// only one of the structs, fields behind nil pointers in src, unexported
+// fields and values that are not assignable are skipped, numbers are
+// converted like in SetFieldValue.  Nil pointers on the way to the fields of
+// dst are allocated.  Panics if dst or src Kind is not Struct, or dst or src
+// is not Indirectable to a struct Kind, or dst is not addressable.
+func (m *Mapper) CopyMatchingFields(dst, src reflect.Value) int {
+	dst = reflect.Indirect(dst)
+	mustBe(dst, reflect.Struct)
+	if !dst.CanAddr() {
+		panic(fmt.Errorf("%s is not addressable", dst.Type()))
+	}
+	src = reflect.Indirect(src)
+	mustBe(src, reflect.Struct)
+
+	dtm := m.TypeMap(dst.Type())
+	stm := m.TypeMap(src.Type())
+
+	n := 0
+	for _, sfi := range stm.Index {
+		if len(sfi.Children) != 0 || stm.Names[sfi.Path] != sfi {
+			continue
+		}
+		dfi, ok := dtm.Names[sfi.Path]
+		if !ok || len(dfi.Children) != 0 {
+			continue
+		}
+
+		s, ok := fieldByIndexesReadOnly(src, sfi.Index)
+		if !ok || !s.CanInterface() {
+			continue
+		}
+		x, ok := assignable(s, dfi.Field.Type)
+		if !ok {
+			continue
+		}
+		d, err := dfi.SettableValue(dst)
+		if err != nil {
+			continue
+		}
+		d.Set(x)
+		n++
+	}
+	return n
+}
+
+// DeepCopy returns a copy of the struct src that has only the mapped fields
+// set, fields that are not mapped ex. unexported fields or fields skipped with
+// the "-" tag are zero.  The values of the fields without children, the same
+// fields as in StructMap.Names, are copied so that they are not shared with
+// src, slices, maps, arrays and pointers are copied recursively, structs,
+// ex. time.Time, are copied by value.  Nil pointers on the way to the fields
+// are allocated in the copy only if the fields are set in src.  If src is a
+// pointer the copy is a pointer to a new struct, otherwise it's a new
+// addressable struct.  Panics if src's Kind is not Struct or src is not
+// Indirectable to a struct Kind.
+func (m *Mapper) DeepCopy(src reflect.Value) reflect.Value {
+	s := reflect.Indirect(src)
+	mustBe(s, reflect.Struct)
+
+	dst := reflect.New(s.Type())
+	tm := m.TypeMap(s.Type())
+	for _, fi := range tm.Index {
+		if len(fi.Children) != 0 || tm.Names[fi.Path] != fi {
+			continue
+		}
+		f, ok := fieldByIndexesReadOnly(s, fi.Index)
+		if !ok || !f.CanInterface() {
+			continue
+		}
+		d, err := fi.SettableValue(dst.Elem())
+		if err != nil {
+			continue
+		}
+		d.Set(deepCopy(f))
+	}
+
+	if src.Kind() == reflect.Ptr {
+		return dst
+	}
+	return dst.Elem()
+}
+
+// deepCopy returns a copy of v that does not share slices, maps, arrays and
+// pointers with v.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	}
+	return v
+}
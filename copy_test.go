@@ -0,0 +1,119 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCopyMatchingFields(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Request struct {
+		*Context
+		Name   string  `db:"name"`
+		Age    float64 `db:"age"`
+		Score  float64 `db:"score"`
+		Tags   string  `db:"tags"`
+		Extra  int     `db:"extra"`
+		secret string  `db:"secret"`
+	}
+	type Model struct {
+		*Context
+		ID     int      `db:"id"`
+		Name   string   `db:"name"`
+		Age    int      `db:"age"`
+		Score  int      `db:"score"`
+		Tags   []string `db:"tags"`
+		Secret string   `db:"secret"`
+	}
+
+	m := NewMapper("db")
+	r := Request{
+		Context: &Context{SessionID: "id"},
+		Name:    "foo",
+		Age:     42,
+		Score:   0.5,
+		Tags:    "a,b",
+		Extra:   1,
+		secret:  "s",
+	}
+	var model Model
+	if n := m.CopyMatchingFields(reflect.ValueOf(&model), reflect.ValueOf(r)); n != 3 {
+		t.Errorf("Expecting %d, got %d", 3, n)
+	}
+	expected := Model{Context: &Context{SessionID: "id"}, Name: "foo", Age: 42}
+	if !reflect.DeepEqual(model, expected) {
+		t.Errorf("Expecting %v, got %v", expected, model)
+	}
+	if model.Context == r.Context {
+		t.Error("Expecting Context to be allocated")
+	}
+
+	model = Model{}
+	r.Context = nil
+	if n := m.CopyMatchingFields(reflect.ValueOf(&model), reflect.ValueOf(&r)); n != 2 {
+		t.Errorf("Expecting %d, got %d", 2, n)
+	}
+	if model.Context != nil {
+		t.Errorf("Expecting nil Context, got %v", model.Context)
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Filter struct {
+		Tags []string `db:"tags"`
+	}
+	type Request struct {
+		*Context
+		Filter  Filter         `db:"filter"`
+		Max     *int           `db:"max"`
+		Labels  map[string]int `db:"labels"`
+		Created time.Time      `db:"created"`
+		Skip    string         `db:"-"`
+		secret  string
+	}
+
+	m := NewMapper("db")
+	max := 10
+	r := Request{
+		Context: &Context{SessionID: "id"},
+		Filter:  Filter{Tags: []string{"a", "b"}},
+		Max:     &max,
+		Labels:  map[string]int{"a": 1},
+		Created: time.Unix(1, 0),
+		Skip:    "skip",
+		secret:  "secret",
+	}
+
+	c := m.DeepCopy(reflect.ValueOf(&r)).Interface().(*Request)
+	expected := r
+	expected.Skip = ""
+	expected.secret = ""
+	if !reflect.DeepEqual(*c, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, *c)
+	}
+	if c.Context == r.Context || c.Max == r.Max || &c.Filter.Tags[0] == &r.Filter.Tags[0] {
+		t.Error("Expecting values not to be shared")
+	}
+	c.Labels["a"] = 2
+	if r.Labels["a"] != 1 {
+		t.Error("Expecting map not to be shared")
+	}
+
+	v := m.DeepCopy(reflect.ValueOf(Request{}))
+	if !v.CanAddr() {
+		t.Error("Expecting addressable struct")
+	}
+	if cp := v.Interface().(Request); cp.Context != nil || cp.Labels != nil {
+		t.Errorf("Expecting nil fields, got %+v", cp)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldDescriptor describes a mapped field, it does not reference any
+// reflect types or values so that it can be encoded ex. to JSON to document
+// request models.
+type FieldDescriptor struct {
+	// Name is the mapped name of the field.
+	Name string `json:"name"`
+	// Path is the full path of the field, for promoted and nested fields it
+	// starts with the path of the enclosing struct.
+	Path string `json:"path"`
+	// Kind is the Go kind of the field, for pointers it's the kind of the
+	// pointed to type, ex. "int" for *int.
+	Kind string `json:"kind"`
+	// Slice is true if the field is a slice.
+	Slice bool `json:"slice"`
+	// Options are the parsed tag options.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Describe returns descriptors of the mapped names of t in struct declaration
+// order, the same fields as in StructMap.Names.
+func (m *Mapper) Describe(t reflect.Type) []FieldDescriptor {
+	tm := m.TypeMap(t)
+
+	r := make([]FieldDescriptor, 0, len(tm.Names))
+	for _, fi := range tm.Index {
+		if tm.Names[fi.Path] != fi {
+			continue
+		}
+		kind := Deref(fi.Field.Type).Kind()
+		d := FieldDescriptor{
+			Name:  fi.Name,
+			Path:  fi.Path,
+			Kind:  kind.String(),
+			Slice: kind == reflect.Slice,
+		}
+		if len(fi.Options) > 0 {
+			d.Options = make(map[string]string, len(fi.Options))
+			for k, v := range fi.Options {
+				d.Options[k] = v
+			}
+		}
+		r = append(r, d)
+	}
+	return r
+}
+
+// FlatFields returns the fields of the mapped names without children, the
+// same fields as in StructMap.Names, in struct declaration order, ex. to
+// create a flattened counterpart type with reflect.StructOf.  Embedded
+// structs and nested struct fields are omitted, only their leaf fields are
+// returned.  The tag of the first tag name of the mapper is set to the path of
+// the field followed by its options, other tags are kept.  Index is the
+// traversal of the field in the mapped struct, Offset is 0 and Anonymous is
+// false.  Note that reflect.StructOf panics on unexported fields, and on
+// fields with the same Go name in different embedded structs.
+func (f StructMap) FlatFields() []reflect.StructField {
+	var fields []reflect.StructField
+	for _, fi := range f.Index {
+		if f.Names[fi.Path] != fi || len(fi.Children) != 0 {
+			continue
+		}
+		sf := fi.Field
+		sf.Index = fi.Index
+		sf.Offset = 0
+		sf.Anonymous = false
+		if f.tagName != "" {
+			sf.Tag = replaceTag(sf.Tag, f.tagName, flatTagValue(fi))
+		}
+		fields = append(fields, sf)
+	}
+	return fields
+}
+
+// flatTagValue returns the path of fi followed by its options, values with
+// commas are quoted.
+func flatTagValue(fi *FieldInfo) string {
+	parts := []string{fi.Path}
+	seen := make(map[string]bool, len(fi.OptionOrder))
+	for _, k := range fi.OptionOrder {
+		v, ok := fi.Options[k]
+		if !ok || seen[k] {
+			continue
+		}
+		seen[k] = true
+		if strings.Contains(v, ",") {
+			v = "'" + v + "'"
+		}
+		if v != "" {
+			k += "=" + v
+		}
+		parts = append(parts, k)
+	}
+	return strings.Join(parts, ",")
+}
+
+// replaceTag returns tag with the value of key set to value, the other
+// key:"value" pairs are kept in order, the key is appended if it's not set.
+// Like in reflect.StructTag.Lookup parsing stops at the first malformed
+// pair.
+func replaceTag(tag reflect.StructTag, key, value string) reflect.StructTag {
+	var (
+		pairs []string
+		found bool
+		s     = string(tag)
+	)
+	for s != "" {
+		// skip leading space
+		i := 0
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		s = s[i:]
+		if s == "" {
+			break
+		}
+
+		// scan to colon, a space, a quote or a control character is a
+		// syntax error
+		i = 0
+		for i < len(s) && s[i] > ' ' && s[i] != ':' && s[i] != '"' && s[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(s) || s[i] != ':' || s[i+1] != '"' {
+			break
+		}
+		name := s[:i]
+		s = s[i+1:]
+
+		// scan quoted string to find value
+		i = 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		qvalue := s[:i+1]
+		s = s[i+1:]
+
+		if name == key {
+			qvalue = strconv.Quote(value)
+			found = true
+		}
+		pairs = append(pairs, name+":"+qvalue)
+	}
+	if !found {
+		pairs = append(pairs, key+":"+strconv.Quote(value))
+	}
+	return reflect.StructTag(strings.Join(pairs, " "))
+}
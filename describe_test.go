@@ -0,0 +1,90 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid,required"`
+	}
+	type Request struct {
+		Context `http:"ctx"`
+		Labels  []string `http:"l"`
+		Max     *int     `http:"max,default=10"`
+	}
+
+	m := NewMapper("http")
+	d := m.Describe(reflect.TypeOf(Request{}))
+
+	expected := []FieldDescriptor{
+		{Name: "sid", Path: "ctx.sid", Kind: "string", Options: map[string]string{"required": ""}},
+		{Name: "l", Path: "l", Kind: "slice", Slice: true},
+		{Name: "max", Path: "max", Kind: "int", Options: map[string]string{"default": "10"}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, d)
+	}
+
+	d[0].Options["required"] = "x"
+	if fi := m.TypeMap(reflect.TypeOf(Request{})).GetByPath("ctx.sid"); fi.Options["required"] != "" {
+		t.Error("Expecting options to be copied")
+	}
+
+	b, err := json.Marshal(d[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := `{"name":"max","path":"max","kind":"int","slice":false,"options":{"default":"10"}}`; string(b) != s {
+		t.Errorf("Expecting %s, got %s", s, b)
+	}
+}
+
+func TestFlatFields(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid,required" json:"session"`
+	}
+	type Limit struct {
+		Max int `http:"max,default=10,oneof='1,10'"`
+	}
+	type Request struct {
+		*Context
+		Limit  Limit    `http:"limit"`
+		Labels []string `json:"labels"`
+	}
+
+	m := NewMapper("http")
+	fields := m.TypeMap(reflect.TypeOf(Request{})).FlatFields()
+
+	var tags []reflect.StructTag
+	var indexes [][]int
+	for _, f := range fields {
+		tags = append(tags, f.Tag)
+		indexes = append(indexes, f.Index)
+	}
+	expectedTags := []reflect.StructTag{
+		`http:"sid,required" json:"session"`,
+		`http:"limit.max,default=10,oneof='1,10'"`,
+		`json:"labels" http:"Labels"`,
+	}
+	if !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("Expecting %v, got %v", expectedTags, tags)
+	}
+	if expected := [][]int{{0, 0}, {1, 0}, {2}}; !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("Expecting %v, got %v", expected, indexes)
+	}
+
+	flat := reflect.StructOf(fields)
+	if names, expected := m.Names(flat), []string{"sid", "limit.max", "Labels"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expecting %v, got %v", expected, names)
+	}
+	if fi := m.TypeMap(flat).GetByPath("limit.max"); fi == nil || fi.Options["oneof"] != "1,10" {
+		t.Errorf("Expecting oneof option, got %v", fi)
+	}
+}
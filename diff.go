@@ -0,0 +1,64 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Diff compares the mapped fields of old and cur and returns the values of
+// the fields that differ indexed by mapped name, the first element is the old
+// value and the second is the new one.  Values are compared with
+// reflect.DeepEqual.  If a field is behind a nil pointer on one side only, it
+// differs and the value on that side is nil.  Unexported fields are ignored.
+// Panics if old and cur are not of the same type or their Kind is not Struct
+// or they are not Indirectable to a struct Kind.
+func (m *Mapper) Diff(old, cur reflect.Value) map[string][2]interface{} {
+	return m.DiffFunc(old, cur, deepEqual)
+}
+
+func deepEqual(a, b reflect.Value) bool {
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// DiffFunc is like Diff but the values are compared with eq, ex. to compare
+// time.Time values with Equal or floats with a tolerance.  eq is not called
+// for fields that are behind a nil pointer on any side.
+func (m *Mapper) DiffFunc(old, cur reflect.Value, eq func(a, b reflect.Value) bool) map[string][2]interface{} {
+	old = reflect.Indirect(old)
+	cur = reflect.Indirect(cur)
+	mustBe(old, reflect.Struct)
+	mustBe(cur, reflect.Struct)
+	if old.Type() != cur.Type() {
+		panic(fmt.Sprintf("cannot diff different types %s and %s", old.Type(), cur.Type()))
+	}
+
+	r := map[string][2]interface{}{}
+	tm := m.TypeMap(old.Type())
+	for name, fi := range tm.Names {
+		o, oldOk := fieldByIndexesReadOnly(old, fi.Index)
+		c, curOk := fieldByIndexesReadOnly(cur, fi.Index)
+		if !oldOk && !curOk {
+			continue
+		}
+		if (oldOk && !o.CanInterface()) || (curOk && !c.CanInterface()) {
+			continue
+		}
+		if oldOk && curOk && eq(o, c) {
+			continue
+		}
+
+		var d [2]interface{}
+		if oldOk {
+			d[0] = o.Interface()
+		}
+		if curOk {
+			d[1] = c.Interface()
+		}
+		r[name] = d
+	}
+	return r
+}
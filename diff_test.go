@@ -0,0 +1,108 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+		TraceID   string `db:"tid"`
+	}
+	type Request struct {
+		*Context
+		Labels []string `db:"l"`
+		Max    int      `db:"max"`
+	}
+
+	m := NewMapper("db")
+
+	testCases := []struct {
+		old      Request
+		cur      Request
+		expected map[string][2]interface{}
+	}{
+		{
+			Request{},
+			Request{},
+			map[string][2]interface{}{},
+		},
+		{
+			Request{Labels: []string{"foo"}, Max: 1},
+			Request{Labels: []string{"foo"}, Max: 2},
+			map[string][2]interface{}{
+				"max": {1, 2},
+			},
+		},
+		{
+			Request{Context: &Context{SessionID: "a"}},
+			Request{Context: &Context{SessionID: "b"}, Labels: []string{"foo"}},
+			map[string][2]interface{}{
+				"sid": {"a", "b"},
+				"l":   {[]string(nil), []string{"foo"}},
+			},
+		},
+		{
+			Request{},
+			Request{Context: &Context{SessionID: "b"}},
+			map[string][2]interface{}{
+				"sid": {nil, "b"},
+				"tid": {nil, ""},
+			},
+		},
+		{
+			Request{Context: &Context{}},
+			Request{},
+			map[string][2]interface{}{
+				"sid": {"", nil},
+				"tid": {"", nil},
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		if v := m.Diff(reflect.ValueOf(tc.old), reflect.ValueOf(&tc.cur)); !reflect.DeepEqual(v, tc.expected) {
+			t.Errorf("%d: expected %v, got %v", i, tc.expected, v)
+		}
+	}
+}
+
+func TestDiffFunc(t *testing.T) {
+	type Event struct {
+		At    time.Time `db:"at"`
+		Score float64   `db:"score"`
+		Name  string    `db:"name"`
+	}
+
+	now := time.Now()
+	old := Event{At: now, Score: 1.0, Name: "a"}
+	cur := Event{At: now.Round(0), Score: 1.0000001, Name: "b"}
+
+	m := NewMapper("db")
+	if d := m.Diff(reflect.ValueOf(old), reflect.ValueOf(cur)); len(d) != 3 {
+		t.Errorf("Expecting %d differences, got %v", 3, d)
+	}
+
+	eq := func(a, b reflect.Value) bool {
+		switch x := a.Interface().(type) {
+		case time.Time:
+			return x.Equal(b.Interface().(time.Time))
+		case float64:
+			return math.Abs(x-b.Float()) < 1e-6
+		}
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+	expected := map[string][2]interface{}{
+		"name": {"a", "b"},
+	}
+	if d := m.DiffFunc(reflect.ValueOf(old), reflect.ValueOf(cur), eq); !reflect.DeepEqual(d, expected) {
+		t.Errorf("Expecting %v, got %v", expected, d)
+	}
+}
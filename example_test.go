@@ -55,7 +55,7 @@ func Search(w http.ResponseWriter, r *http.Request) {
 // the URL parameters and calls setValue to do the value assignment.
 func bindParams(req *http.Request, dest interface{}) error {
 	v := reflect.Indirect(reflect.ValueOf(dest))
-	m := DefaultMapper.FieldMap(v)
+	m := DefaultMapper.FieldMapAlloc(v)
 	for name, values := range req.Form {
 		f, ok := m[name]
 		if !ok {
@@ -70,10 +70,17 @@ func bindParams(req *http.Request, dest interface{}) error {
 	return nil
 }
 
-// setValue unmarshals string representation of string, int or bool into
-// a field using a "kind switch".
+// setValue unmarshals string representation of string, int or bool, or
+// a pointer to one of them, into a field using a "kind switch".
 func setValue(v reflect.Value, value string) error {
 	switch v.Kind() {
+	// Pointers are allocated, the value is set to the new element.
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := setValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(elem)
 	// Special handling of slices.
 	case reflect.Slice:
 		elem := reflect.New(v.Type().Elem()).Elem()
@@ -110,3 +117,86 @@ func TestSearch(t *testing.T) {
 		t.Fatal(w.Code)
 	}
 }
+
+func TestTypeMapPointer(t *testing.T) {
+	m := reflectx.NewMapper("http")
+	tm := m.TypeMap(reflect.TypeOf(SearchRequest{}))
+	if ptm := m.TypeMap(reflect.TypeOf(&SearchRequest{})); ptm != tm {
+		t.Errorf("Expecting %p, got %p", tm, ptm)
+	}
+	if fi := tm.GetByPath("max"); fi == nil {
+		t.Error("Expecting max to be mapped")
+	}
+
+	m.InvalidateType(reflect.TypeOf(&SearchRequest{}))
+	if m.TypeMap(reflect.TypeOf(SearchRequest{})) == tm {
+		t.Error("Expecting new mapping after InvalidateType")
+	}
+}
+
+func TestSubStructByPath(t *testing.T) {
+	m := reflectx.NewMapper("http")
+	data := SearchRequest{RequestContext: RequestContext{SessionID: "id"}, MaxResults: 10}
+	v := reflect.ValueOf(&data)
+
+	ctx, ok := m.SubStructByPath(v, "RequestContext")
+	if !ok {
+		t.Fatal("Expecting RequestContext")
+	}
+	if c, ok := ctx.Interface().(RequestContext); !ok || c.SessionID != "id" {
+		t.Errorf("Expecting %v, got %v", data.RequestContext, ctx)
+	}
+	ctx.Set(reflect.ValueOf(RequestContext{SessionID: "other"}))
+	if data.SessionID != "other" {
+		t.Errorf("Expecting %s, got %s", "other", data.SessionID)
+	}
+
+	for _, path := range []string{"max", "sid", "missing"} {
+		if _, ok := m.SubStructByPath(v, path); ok {
+			t.Errorf("Expecting no struct for %s", path)
+		}
+	}
+}
+
+func ExampleTypeMapOf() {
+	// A pointer type parameter maps the type it points to.
+	fields := reflectx.TypeMapOf[*SearchRequest](DefaultMapper)
+	fmt.Println(fields.GetByPath("max").Field.Name)
+	fmt.Println(fields.GetByPath("sid").Field.Name)
+	// Output:
+	// MaxResults
+	// SessionID
+}
+
+func ExampleMapper_FieldPointers() {
+	type Audit struct {
+		CreatedBy string `db:"created_by"`
+	}
+	type User struct {
+		*Audit
+		ID int `db:"id"`
+	}
+
+	m := reflectx.NewMapper("db")
+	columns := []string{"id", "created_by"}
+	traversals := m.TraversalsByName(reflect.TypeOf(User{}), columns)
+
+	var u User
+	ptrs, err := m.FieldPointers(reflect.ValueOf(&u), traversals)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Scan is a stand-in for rows.Scan.
+	scan := func(dest ...interface{}) error {
+		*dest[0].(*int) = 7
+		*dest[1].(*string) = "admin"
+		return nil
+	}
+	if err := scan(ptrs...); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(u.ID, u.CreatedBy)
+	// Output:
+	// 7 admin
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldByNameAs returns the value of a field by its mapped name as T.  It
+// returns the zero T and false if the name is not found or if the field's type
+// is not assignable to T.  Panics if v's Kind is not Struct or v is not
+// Indirectable to a struct Kind.
+func FieldByNameAs[T any](m *Mapper, v reflect.Value, name string) (T, bool) {
+	var zero T
+
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	if _, ok := m.TypeMap(v.Type()).lookup(name); !ok {
+		return zero, false
+	}
+
+	f := m.FieldByName(v, name)
+	if !f.CanInterface() || !f.Type().AssignableTo(reflect.TypeOf(&zero).Elem()) {
+		return zero, false
+	}
+	// a nil interface value converts to the zero T
+	t, _ := f.Interface().(T)
+	return t, true
+}
+
+// TypeMapOf returns a mapping of field strings to int slices representing
+// the traversal down the struct to reach the field for the type T.  If T is
+// a pointer type the type it points to is mapped.
+func TypeMapOf[T any](m *Mapper) *StructMap {
+	return m.TypeMap(Deref(reflect.TypeOf((*T)(nil)).Elem()))
+}
+
+// Register builds and caches the mapping of the type T like RegisterTypes.
+func Register[T any](m *Mapper) {
+	TypeMapOf[T](m)
+}
+
+// Scan calls scan, ex. the Scan method of *sql.Rows, with pointers to the
+// fields of dest for the columns, the fields are found by the mapped names of
+// T.  Nil pointers along the traversals are allocated.  It returns an error
+// without calling scan if any of the columns is not mapped or T is not a
+// struct.
+func Scan[T any](m *Mapper, columns []string, dest *T, scan func(dest ...interface{}) error) error {
+	v := reflect.ValueOf(dest).Elem()
+	if err := mustBeStruct(v); err != nil {
+		return err
+	}
+
+	traversals := m.CachedTraversals(v.Type(), columns)
+	values := make([]interface{}, len(columns))
+	for i, idx := range traversals {
+		if len(idx) == 0 {
+			return fmt.Errorf("missing destination name %q in %s", columns[i], v.Type())
+		}
+		values[i] = FieldByIndexes(v, idx).Addr().Interface()
+	}
+	return scan(values...)
+}
+
+// ScanStrict is like Scan but after scanning it checks that every leaf field
+// of T, see Mapper.LeafNames, got a value from the columns, ex. to catch a new
+// column that is not selected.  A column mapped to a struct field covers all
+// its fields.  It returns an error listing the names of the fields that are
+// not covered by any column, dest is scanned regardless.
+func ScanStrict[T any](m *Mapper, columns []string, dest *T, scan func(dest ...interface{}) error) error {
+	if err := Scan(m, columns, dest, scan); err != nil {
+		return err
+	}
+
+	t := reflect.TypeOf(dest).Elem()
+	tm := m.TypeMap(t)
+	covered := make(map[*FieldInfo]bool, len(columns))
+	for _, c := range columns {
+		if fi, ok := tm.lookup(c); ok {
+			covered[fi] = true
+		}
+	}
+
+	var missing []string
+	for _, name := range m.LeafNames(t) {
+		ok := false
+		for fi := tm.Names[name]; fi != nil && !ok; fi = fi.Parent {
+			ok = covered[fi]
+		}
+		if !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing columns for %s: %s", t, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// TypedMapper is a Mapper for a single struct type T.  The mapping of T is
+// built when the TypedMapper is created.
+type TypedMapper[T any] struct {
+	m *Mapper
+	t reflect.Type
+}
+
+// NewTypedMapper returns a new TypedMapper using the tagName as its struct
+// field tag like NewMapper.  Panics if T is not a struct type.
+func NewTypedMapper[T any](tagName string) *TypedMapper[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	mustBe(t, reflect.Struct)
+
+	m := NewMapper(tagName)
+	m.TypeMap(t)
+	return &TypedMapper[T]{m: m, t: t}
+}
+
+// FieldMap returns the mapper's mapping of field names to reflect values,
+// fields behind nil pointers are omitted, see Mapper.FieldMap.
+func (tm *TypedMapper[T]) FieldMap(v *T) map[string]reflect.Value {
+	return tm.m.FieldMap(reflect.ValueOf(v))
+}
+
+// FieldMapAlloc is like FieldMap but nil pointers are allocated, see
+// Mapper.FieldMapAlloc.
+func (tm *TypedMapper[T]) FieldMapAlloc(v *T) map[string]reflect.Value {
+	return tm.m.FieldMapAlloc(reflect.ValueOf(v))
+}
+
+// FieldByName returns a field by its mapped name as a reflect.Value.  Returns
+// zero Value if the name is not found.
+func (tm *TypedMapper[T]) FieldByName(v *T, name string) reflect.Value {
+	return tm.m.FieldByName(reflect.ValueOf(v), name)
+}
+
+// Names returns the mapped names of T in struct declaration order.
+func (tm *TypedMapper[T]) Names() []string {
+	return tm.m.Names(tm.t)
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFieldByNameAs(t *testing.T) {
+	type Foo struct {
+		A int          `db:"a"`
+		B string       `db:"b"`
+		S fmt.Stringer `db:"s"`
+	}
+
+	m := NewMapper("db")
+	f := Foo{A: 1, B: "b"}
+	fv := reflect.ValueOf(f)
+
+	if v, ok := FieldByNameAs[int](m, fv, "a"); !ok || v != f.A {
+		t.Errorf("Expecting %d, got %d (%v)", f.A, v, ok)
+	}
+	if v, ok := FieldByNameAs[string](m, fv, "b"); !ok || v != f.B {
+		t.Errorf("Expecting %s, got %s (%v)", f.B, v, ok)
+	}
+	if v, ok := FieldByNameAs[interface{}](m, fv, "a"); !ok || v != f.A {
+		t.Errorf("Expecting %d, got %v (%v)", f.A, v, ok)
+	}
+	if v, ok := FieldByNameAs[string](m, fv, "a"); ok || v != "" {
+		t.Errorf("Expecting type mismatch, got %q (%v)", v, ok)
+	}
+	if v, ok := FieldByNameAs[int](m, fv, "c"); ok || v != 0 {
+		t.Errorf("Expecting missing field, got %d (%v)", v, ok)
+	}
+	if v, ok := FieldByNameAs[fmt.Stringer](m, fv, "s"); !ok || v != nil {
+		t.Errorf("Expecting nil interface, got %v (%v)", v, ok)
+	}
+}
+
+func TestTypeMapOf(t *testing.T) {
+	type Foo struct {
+		A int `db:"a"`
+	}
+
+	m := NewMapper("db")
+	fields := m.TypeMap(reflect.TypeOf(Foo{}))
+	if tm := TypeMapOf[Foo](m); tm != fields {
+		t.Errorf("Expecting cached mapping %p, got %p", fields, tm)
+	}
+	if tm := TypeMapOf[*Foo](m); tm != fields {
+		t.Errorf("Expecting cached mapping %p, got %p", fields, tm)
+	}
+	if fi := TypeMapOf[*Foo](m).GetByPath("a"); fi == nil {
+		t.Error("Expecting to find key a in mapping but did not.")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	type Foo struct {
+		A int `db:"a"`
+	}
+
+	m := NewMapper("db")
+	Register[*Foo](m)
+	if _, ok := m.cache.Load(reflect.TypeOf(Foo{})); !ok {
+		t.Error("Expecting Foo to be cached")
+	}
+}
+
+func TestTypedMapper(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Foo struct {
+		*Context
+		A int `db:"a"`
+	}
+
+	tm := NewTypedMapper[Foo]("db")
+	if v, expected := tm.Names(), []string{"sid", "a"}; !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expecting %v, got %v", expected, v)
+	}
+
+	f := Foo{A: 1}
+	if fm := tm.FieldMap(&f); len(fm) != 1 {
+		t.Errorf("Expecting %d keys, got %d", 1, len(fm))
+	}
+	fm := tm.FieldMapAlloc(&f)
+	if len(fm) != 2 {
+		t.Errorf("Expecting %d keys, got %d", 2, len(fm))
+	}
+	fm["sid"].SetString("id")
+	if f.Context == nil || f.SessionID != "id" {
+		t.Errorf("Expecting %s, got %+v", "id", f.Context)
+	}
+
+	if v := tm.FieldByName(&f, "a"); ival(v) != f.A {
+		t.Errorf("Expecting %d, got %d", f.A, ival(v))
+	}
+	if v := tm.FieldByName(&f, "b"); v.IsValid() {
+		t.Errorf("Expecting zero Value, got %v", v)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expecting panic for non struct type")
+		}
+	}()
+	NewTypedMapper[*Foo]("db")
+}
+
+func TestScan(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Row struct {
+		*Context
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+
+	m := NewMapper("db")
+	scan := func(dest ...interface{}) error {
+		*dest[0].(*string) = "name"
+		*dest[1].(*int) = 1
+		*dest[2].(*string) = "sid"
+		return nil
+	}
+
+	var r Row
+	if err := Scan(m, []string{"name", "id", "sid"}, &r, scan); err != nil {
+		t.Fatal(err)
+	}
+	expected := Row{Context: &Context{SessionID: "sid"}, ID: 1, Name: "name"}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, r)
+	}
+
+	called := false
+	err := Scan(m, []string{"id", "missing"}, &r, func(dest ...interface{}) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Fatal("Expecting error for unmapped column")
+	}
+	if !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("Expecting error to include column name, got %s", err)
+	}
+
+	i := 0
+	if err := Scan(m, []string{"id"}, &i, scan); err == nil {
+		t.Error("Expecting error for non struct destination")
+	}
+}
+
+func TestScanStrict(t *testing.T) {
+	type Address struct {
+		City string `db:"city"`
+		Zip  string `db:"zip"`
+	}
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Row struct {
+		*Context
+		ID      int     `db:"id"`
+		Name    string  `db:"name"`
+		Address Address `db:"address"`
+	}
+
+	m := NewMapper("db")
+	scan := func(dest ...interface{}) error {
+		return nil
+	}
+
+	var r Row
+	columns := []string{"sid", "id", "name", "address.city", "address.zip"}
+	if err := ScanStrict(m, columns, &r, scan); err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanStrict(m, []string{"sid", "id", "name", "address"}, &r, scan); err != nil {
+		t.Errorf("Expecting struct column to cover its fields, got %v", err)
+	}
+
+	err := ScanStrict(m, []string{"id", "address.city"}, &r, scan)
+	if err == nil {
+		t.Fatal("Expecting error for missing columns")
+	}
+	for _, name := range []string{"sid", "name", "address.zip"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expecting error to include %s, got %s", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "city") {
+		t.Errorf("Expecting error not to include city, got %s", err)
+	}
+
+	if err := ScanStrict(m, []string{"id", "missing"}, &r, scan); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("Expecting error for unmapped column, got %v", err)
+	}
+}
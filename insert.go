@@ -0,0 +1,56 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PlaceholderStyle is the style of the bind placeholders of SQL queries.
+type PlaceholderStyle int
+
+// Placeholder styles.
+const (
+	// Question is the "?" style used ex. by MySQL and SQLite.
+	Question PlaceholderStyle = iota
+	// Dollar is the "$1" style used ex. by PostgreSQL.
+	Dollar
+)
+
+// InsertColumns returns the columns of t for an INSERT query and the matching
+// placeholders joined with ", ", ex. "?, ?" or "$1, $2".  The columns are the
+// mapped names of the fields without children, the same fields as in
+// StructMap.Names, in struct declaration order.  Fields with the
+// "autoincrement" or "readonly" option are excluded, ex. `db:"id,autoincrement"`.
+func (m *Mapper) InsertColumns(t reflect.Type, style PlaceholderStyle) (columns []string, placeholders string) {
+	tm := m.TypeMap(t)
+
+	var b strings.Builder
+	for _, fi := range tm.Index {
+		if tm.Names[fi.Path] != fi || len(fi.Children) != 0 {
+			continue
+		}
+		if _, ok := fi.Options["autoincrement"]; ok {
+			continue
+		}
+		if _, ok := fi.Options["readonly"]; ok {
+			continue
+		}
+
+		columns = append(columns, fi.Path)
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		if style == Dollar {
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(len(columns)))
+		} else {
+			b.WriteByte('?')
+		}
+	}
+	return columns, b.String()
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInsertColumns(t *testing.T) {
+	type Audit struct {
+		CreatedBy string `db:"created_by"`
+		Version   int    `db:"version,readonly"`
+	}
+	type Address struct {
+		City string `db:"city"`
+	}
+	type User struct {
+		*Audit
+		ID      int     `db:"id,autoincrement"`
+		Name    string  `db:"name"`
+		Address Address `db:"address"`
+		Skip    int     `db:"-"`
+	}
+
+	m := NewMapper("db")
+	typ := reflect.TypeOf(User{})
+	columns := []string{"created_by", "name", "address.city"}
+
+	c, p := m.InsertColumns(typ, Question)
+	if !reflect.DeepEqual(c, columns) {
+		t.Errorf("Expecting %v, got %v", columns, c)
+	}
+	if expected := "?, ?, ?"; p != expected {
+		t.Errorf("Expecting %s, got %s", expected, p)
+	}
+
+	c, p = m.InsertColumns(typ, Dollar)
+	if !reflect.DeepEqual(c, columns) {
+		t.Errorf("Expecting %v, got %v", columns, c)
+	}
+	if expected := "$1, $2, $3"; p != expected {
+		t.Errorf("Expecting %s, got %s", expected, p)
+	}
+}
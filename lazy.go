@@ -0,0 +1,144 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// LazyStructMap is a mapping of a struct that maps the fields of nested
+// structs on first use, see Mapper.LazyTypeMap.
+type LazyStructMap struct {
+	top  *StructMap
+	rt   reflect.Type
+	opts *mapOptions
+	// subtrees are the nested struct fields of top by FieldInfo, the map is
+	// not modified after it's built.
+	subtrees map[*FieldInfo]*lazySubtree
+}
+
+// lazySubtree is a nested struct field mapped on first use.
+type lazySubtree struct {
+	once sync.Once
+	tq   typeQueue
+	tree *LazyStructMap
+}
+
+// LazyTypeMap returns a mapping of t that maps the fields of nested, not
+// embedded, struct fields only when they are first queried, ex. with
+// GetByPath, so that the first access to the shallow fields of a large type
+// does not pay for mapping all of it.  Every nested struct is mapped once,
+// concurrent queries wait for the mapping.  The fields of embedded structs
+// are mapped with the struct because their names are promoted and they
+// compete with the names of the struct.  The fields are the same as in the
+// mapping returned by TypeMap, except that the invalid tags of nested
+// structs are not reported, use TypeMapE to check them.  The mappings are
+// built with the settings of the mapper at the time of the call and they
+// are cached apart from the mappings returned by TypeMap, they are dropped by
+// ClearCache and InvalidateType.
+func (m *Mapper) LazyTypeMap(t reflect.Type) *LazyStructMap {
+	t = Deref(t)
+	if m.closed.Load() {
+		return m.buildLazy(t)
+	}
+
+	v, ok := m.lazyCache.Load(t)
+	if !ok {
+		v, _ = m.lazyCache.LoadOrStore(t, &lazyMapEntry{})
+		// see TypeMap
+		if m.closed.Load() {
+			m.lazyCache.CompareAndDelete(t, v)
+			return m.buildLazy(t)
+		}
+	}
+	e := v.(*lazyMapEntry)
+	e.once.Do(func() {
+		e.mapping = m.buildLazy(t)
+	})
+	return e.mapping
+}
+
+// lazyMapEntry is a cache entry of LazyTypeMap, see typeMapEntry.
+type lazyMapEntry struct {
+	once    sync.Once
+	mapping *LazyStructMap
+}
+
+// buildLazy maps the top level of t with a copy of the settings, the
+// settings are replaced and not modified when they change so the copy can be
+// used to map the nested structs later.
+func (m *Mapper) buildLazy(t reflect.Type) *LazyStructMap {
+	m.mu.RLock()
+	opts := m.opts
+	m.mu.RUnlock()
+	return newLazyStructMap(t, typeQueue{t, &FieldInfo{}, "", ""}, &opts)
+}
+
+// newLazyStructMap maps the fields of the struct of tq without the fields of
+// nested structs, t is the root type.
+func newLazyStructMap(t reflect.Type, tq typeQueue, opts *mapOptions) *LazyStructMap {
+	l := &LazyStructMap{
+		rt:       t,
+		opts:     opts,
+		subtrees: map[*FieldInfo]*lazySubtree{},
+	}
+	buildTree(t, tq, opts, func(tq typeQueue) {
+		l.subtrees[tq.fi] = &lazySubtree{tq: tq}
+	})
+	l.top = newStructMap(tq.fi, opts)
+	return l
+}
+
+// Top returns the mapping of the fields of the struct and its embedded
+// structs, the nested struct fields are mapped without Children, use
+// Subtree to map their fields.  The mapping must not be modified.
+func (l *LazyStructMap) Top() *StructMap {
+	return l.top
+}
+
+// Subtree returns the mapping of the fields of the nested struct field fi of
+// Top, it's built on the first call.  The paths and the traversals of the
+// fields are relative to the root struct of l like in TypeMap.  Returns nil
+// if fi is not a nested struct field of Top.
+func (l *LazyStructMap) Subtree(fi *FieldInfo) *LazyStructMap {
+	s, ok := l.subtrees[fi]
+	if !ok {
+		return nil
+	}
+	s.once.Do(func() {
+		// the fields of the nested struct are children of a copy of fi so
+		// that the FieldInfos of top are not modified
+		root := *s.tq.fi
+		tq := s.tq
+		tq.fi = &root
+		s.tree = newLazyStructMap(l.rt, tq, l.opts)
+	})
+	return s.tree
+}
+
+// GetByPath is like StructMap.GetByPath, the nested structs on the path are
+// mapped if they are not mapped yet.  Returns nil if the path is not found.
+// The fields of nested structs named by the path func of NewMapperPathFunc
+// do not have the path of the struct as a prefix, they are found only with
+// Subtree.
+func (l *LazyStructMap) GetByPath(path string) *FieldInfo {
+	if fi, ok := l.top.Paths[path]; ok {
+		return fi
+	}
+	for fi, s := range l.subtrees {
+		sep := s.tq.sep
+		if sep == "" {
+			sep = l.opts.separator()
+		}
+		if strings.HasPrefix(path, fi.Path) && strings.HasPrefix(path[len(fi.Path):], sep) {
+			if fi := l.Subtree(fi).GetByPath(path); fi != nil {
+				return fi
+			}
+		}
+	}
+	return nil
+}
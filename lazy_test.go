@@ -0,0 +1,133 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLazyTypeMap(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Leaf struct {
+		V int `db:"v,required"`
+	}
+	type Inner struct {
+		Leaf `db:"leaf"`
+		W    int `db:"w"`
+	}
+	type Node struct {
+		Val  int   `db:"val"`
+		Next *Node `db:"next"`
+	}
+	type Outer struct {
+		*Context
+		A    int    `db:"a"`
+		B    Inner  `db:"b"`
+		C    *Inner `db:"c"`
+		List Node   `db:"list"`
+	}
+
+	m := NewMapper("db")
+	typ := reflect.TypeOf(Outer{})
+	l := m.LazyTypeMap(typ)
+	if m.LazyTypeMap(reflect.PtrTo(typ)) != l {
+		t.Error("Expecting cached mapping")
+	}
+
+	built := func() []string {
+		var paths []string
+		for fi, s := range l.subtrees {
+			if s.tree != nil {
+				paths = append(paths, fi.Path)
+			}
+		}
+		return paths
+	}
+	top := l.Top()
+	for _, path := range []string{"sid", "a", "b", "c", "list"} {
+		if fi := l.GetByPath(path); fi == nil || top.Paths[path] != fi {
+			t.Errorf("Expecting %s in Top, got %v", path, fi)
+		}
+	}
+	if fi := top.GetByPath("b"); len(fi.Children) != 0 {
+		t.Errorf("Expecting nested struct without children, got %v", fi.Children)
+	}
+	if paths := built(); len(paths) != 0 {
+		t.Errorf("Expecting no subtrees to be built, got %v", paths)
+	}
+
+	if fi := l.GetByPath("b.leaf.v"); fi == nil || !reflect.DeepEqual(fi.Index, []int{2, 0, 0}) {
+		t.Errorf("Expecting b.leaf.v, got %v", fi)
+	}
+	if paths := built(); !reflect.DeepEqual(paths, []string{"b"}) {
+		t.Errorf("Expecting only b to be built, got %v", paths)
+	}
+	if l.GetByPath("b.x") != nil || l.GetByPath("bb.w") != nil {
+		t.Error("Expecting unknown paths not to be found")
+	}
+
+	tm := m.TypeMap(typ)
+	for path, fi := range tm.Paths {
+		lfi := l.GetByPath(path)
+		if lfi == nil {
+			t.Errorf("Expecting %s to be found", path)
+			continue
+		}
+		if !reflect.DeepEqual(lfi.Index, fi.Index) || lfi.Name != fi.Name || !reflect.DeepEqual(lfi.Options, fi.Options) || lfi.FullName() != fi.FullName() {
+			t.Errorf("%s: expecting %+v, got %+v", path, fi, lfi)
+		}
+	}
+	if top.GetByPath("b").Children != nil {
+		t.Error("Expecting Top not to be modified")
+	}
+	if l.Subtree(tm.GetByPath("b")) != nil {
+		t.Error("Expecting nil Subtree of a field of another mapping")
+	}
+
+	m.ClearCache()
+	if m.LazyTypeMap(typ) == l {
+		t.Error("Expecting new mapping after ClearCache")
+	}
+}
+
+func TestLazyTypeMapConcurrent(t *testing.T) {
+	typ := nestedType(2, 3)
+	l := NewMapper("").LazyTypeMap(typ)
+
+	const n = 8
+	fields := make([]*FieldInfo, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fields[i] = l.GetByPath("N1.N0.N1.F1")
+		}(i)
+	}
+	wg.Wait()
+	for _, fi := range fields {
+		if fi == nil || fi != fields[0] {
+			t.Fatalf("Expecting the same field, got %v", fields)
+		}
+	}
+}
+
+// BenchmarkLazyTypeMapNested is like BenchmarkTypeMapNested but the nested
+// structs are not mapped, it takes 9514 ns/op, 72 allocs/op compared to
+// 1652184 ns/op, 9948 allocs/op of mapping all of the type with TypeMap.
+func BenchmarkLazyTypeMapNested(b *testing.B) {
+	typ := nestedType(4, 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if fi := NewMapper("").LazyTypeMap(typ).GetByPath("F0"); fi == nil {
+			b.Fatal("Expecting F0")
+		}
+	}
+}
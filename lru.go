@@ -0,0 +1,100 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// NewMapperLRU returns a new mapper using the tagName as its struct field tag
+// that caches at most maxEntries mappings, if there are more the least
+// recently used mapping is dropped.  This is useful when mapping many short
+// lived types ex. created with reflect.StructOf.  If maxEntries is 0 the cache
+// is unbounded like in NewMapper.
+func NewMapperLRU(tagName string, maxEntries int) *Mapper {
+	m := NewMapper(tagName)
+	if maxEntries > 0 {
+		m.lru = newTypeLRU(maxEntries)
+	}
+	return m
+}
+
+// typeLRU tracks the order in which the cached types are used.
+type typeLRU struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	elems      map[reflect.Type]*list.Element
+}
+
+// lruEntry is a type tracked by typeLRU with its cache entry, so that an
+// evicted entry is dropped only if it's still the cached entry of the type.
+type lruEntry struct {
+	t reflect.Type
+	e *typeMapEntry
+}
+
+func newTypeLRU(maxEntries int) *typeLRU {
+	return &typeLRU{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		elems:      make(map[reflect.Type]*list.Element),
+	}
+}
+
+// touch marks t with the cache entry e as the most recently used type, if
+// there are too many types the least recently used ones are removed and
+// passed to evict.  The type is not tracked if cached reports that e is not
+// the cached entry of t any more, ex. it was evicted after it was loaded, so
+// that the tracked types do not outnumber the cached ones.
+func (c *typeLRU) touch(t reflect.Type, e *typeMapEntry, cached func(t reflect.Type, e *typeMapEntry) bool, evict func(t reflect.Type, e *typeMapEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !cached(t, e) {
+		return
+	}
+	if el, ok := c.elems[t]; ok {
+		el.Value.(*lruEntry).e = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.elems[t] = c.ll.PushFront(&lruEntry{t: t, e: e})
+
+	for c.ll.Len() > c.maxEntries {
+		le := c.ll.Remove(c.ll.Back()).(*lruEntry)
+		delete(c.elems, le.t)
+		evict(le.t, le.e)
+	}
+}
+
+// remove stops tracking t.
+func (c *typeLRU) remove(t reflect.Type) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.elems[t]; ok {
+		c.ll.Remove(e)
+		delete(c.elems, t)
+	}
+}
+
+// clear stops tracking all types.
+func (c *typeLRU) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.elems = make(map[reflect.Type]*list.Element)
+}
+
+// len returns the number of tracked types.
+func (c *typeLRU) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
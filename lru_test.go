@@ -0,0 +1,136 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func cacheLen(m *Mapper) int {
+	n := 0
+	m.cache.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestNewMapperLRU(t *testing.T) {
+	type A struct {
+		A int `db:"a"`
+	}
+	type B struct {
+		B int `db:"b"`
+	}
+	type C struct {
+		C int `db:"c"`
+	}
+
+	m := NewMapperLRU("db", 2)
+	a := m.TypeMap(reflect.TypeOf(A{}))
+	b := m.TypeMap(reflect.TypeOf(B{}))
+	if m.TypeMap(reflect.TypeOf(A{})) != a {
+		t.Error("Expecting cached mapping of A")
+	}
+	m.TypeMap(reflect.TypeOf(C{}))
+
+	if n := cacheLen(m); n != 2 {
+		t.Errorf("Expecting 2 cached mappings, got %d", n)
+	}
+	if m.TypeMap(reflect.TypeOf(A{})) != a {
+		t.Error("Expecting recently used mapping of A to be kept")
+	}
+	if m.TypeMap(reflect.TypeOf(B{})) == b {
+		t.Error("Expecting least recently used mapping of B to be dropped")
+	}
+
+	m.InvalidateType(reflect.TypeOf(A{}))
+	if n := m.lru.len(); n != 1 {
+		t.Errorf("Expecting 1 tracked type, got %d", n)
+	}
+	m.ClearCache()
+	if n := m.lru.len(); n != 0 {
+		t.Errorf("Expecting no tracked types, got %d", n)
+	}
+
+	if m := NewMapperLRU("db", 0); m.lru != nil {
+		t.Error("Expecting unbounded cache")
+	}
+}
+
+func TestNewMapperLRUConcurrent(t *testing.T) {
+	const maxEntries = 10
+
+	types := make([]reflect.Type, 100)
+	for i := range types {
+		types[i] = reflect.StructOf([]reflect.StructField{{
+			Name: "F",
+			Type: reflect.TypeOf(0),
+			Tag:  reflect.StructTag(fmt.Sprintf(`db:"f%d"`, i)),
+		}})
+	}
+
+	m := NewMapperLRU("db", maxEntries)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range types {
+				tt := types[(i+g)%len(types)]
+				name := fmt.Sprintf("f%d", (i+g)%len(types))
+				if fi := m.TypeMap(tt).GetByPath(name); fi == nil {
+					t.Errorf("Expecting to find key %s in mapping but did not.", name)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := m.lru.len(); n > maxEntries {
+		t.Errorf("Expecting at most %d tracked types, got %d", maxEntries, n)
+	}
+	if n := cacheLen(m); n > maxEntries {
+		t.Errorf("Expecting at most %d cached mappings, got %d", maxEntries, n)
+	}
+}
+
+func TestNewMapperLRUClearCacheConcurrent(t *testing.T) {
+	const maxEntries = 4
+
+	types := make([]reflect.Type, 16)
+	for i := range types {
+		types[i] = reflect.StructOf([]reflect.StructField{{Name: fmt.Sprintf("F%d", i), Type: reflect.TypeOf(0)}})
+	}
+
+	m := NewMapperLRU("db", maxEntries)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.TypeMap(types[(i+g)%len(types)])
+				if i%10 == 0 {
+					m.ClearCache()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	m.cache.Range(func(key, _ interface{}) bool {
+		if _, ok := m.lru.elems[key.(reflect.Type)]; !ok {
+			t.Errorf("Expecting cached type %v to be tracked", key)
+		}
+		return true
+	})
+	if n := cacheLen(m); n > maxEntries {
+		t.Errorf("Expecting at most %d cached mappings, got %d", maxEntries, n)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+// Merge returns a mapping of a struct type composed of the fields of the
+// base type followed by the fields of the ext type, ex. created with
+// reflect.StructOf, without mapping the composed type from scratch.  The
+// fields of ext start at field index offset of the composed type, usually it's
+// the number of fields of the base type.  Names present in both base and ext
+// are resolved like in TypeMap, the shallowest field wins and names at the
+// same depth are ambiguous and left out.  The base and ext mappings are not
+// modified, the mapping is not cached.
+func (m *Mapper) Merge(base, ext *StructMap, offset int) *StructMap {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := len(base.Tree.Children)
+	if l := offset + len(ext.Tree.Children); l > n {
+		n = l
+	}
+
+	root := &FieldInfo{Children: make([]*FieldInfo, n)}
+	for i, fi := range base.Tree.Children {
+		if fi != nil {
+			root.Children[i] = copyFieldInfo(fi, root, 0)
+		}
+	}
+	for i, fi := range ext.Tree.Children {
+		if fi != nil {
+			root.Children[offset+i] = copyFieldInfo(fi, root, offset)
+		}
+	}
+	return newStructMap(root, &m.opts)
+}
+
+// copyFieldInfo returns a deep copy of the tree fi with the first element of
+// the indexes increased by offset.
+func copyFieldInfo(fi *FieldInfo, parent *FieldInfo, offset int) *FieldInfo {
+	c := *fi
+	c.Parent = parent
+	c.Index = append([]int{fi.Index[0] + offset}, fi.Index[1:]...)
+	if parent.Parent == nil {
+		c.Field.Index = []int{c.Index[0]}
+	}
+	if fi.Children != nil {
+		c.Children = make([]*FieldInfo, len(fi.Children))
+		for i, child := range fi.Children {
+			if child != nil {
+				c.Children[i] = copyFieldInfo(child, &c, offset)
+			}
+		}
+	}
+	return &c
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Extra struct {
+		SessionID string `db:"sid"`
+		Name      string `db:"name"`
+		Note      string `db:"note"`
+	}
+	type Base struct {
+		Context
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+	type Ext struct {
+		Title string `db:"title"`
+		Extra
+	}
+	type Composed struct {
+		Context
+		ID    int    `db:"id"`
+		Name  string `db:"name"`
+		Title string `db:"title"`
+		Extra
+	}
+
+	m := NewMapper("db")
+	base := m.TypeMap(reflect.TypeOf(Base{}))
+	ext := m.TypeMap(reflect.TypeOf(Ext{}))
+	merged := m.Merge(base, ext, reflect.TypeOf(Base{}).NumField())
+	expected := m.TypeMap(reflect.TypeOf(Composed{}))
+
+	if len(merged.Names) != len(expected.Names) {
+		t.Errorf("Expecting %d names, got %d", len(expected.Names), len(merged.Names))
+	}
+	for name, e := range expected.Names {
+		fi, ok := merged.Names[name]
+		if !ok {
+			t.Errorf("Expecting to find key %s in mapping but did not.", name)
+			continue
+		}
+		if !reflect.DeepEqual(fi.Index, e.Index) {
+			t.Errorf("%s: expecting %v, got %v", name, e.Index, fi.Index)
+		}
+	}
+	if _, ok := merged.Names["sid"]; ok {
+		t.Error("Expecting sid to be ambiguous")
+	}
+	if fi := merged.Names["name"]; fi == nil || !reflect.DeepEqual(fi.Index, []int{2}) {
+		t.Errorf("Expecting shallowest name to win, got %+v", fi)
+	}
+	if fi := merged.GetByTraversal([]int{4, 2}); fi == nil || fi.Path != "note" {
+		t.Errorf("Expecting note at [4 2], got %+v", fi)
+	}
+	if f, _ := reflect.TypeOf(Composed{}).FieldByName("Title"); merged.GetByTraversal(f.Index) == nil {
+		t.Error("Expecting to find Title by its index in the composed type")
+	}
+
+	if fi := ext.Names["title"]; !reflect.DeepEqual(fi.Index, []int{0}) {
+		t.Errorf("Expecting ext not to be modified, got %v", fi.Index)
+	}
+	if fi := ext.Names["note"]; fi.Parent.Parent != ext.Tree {
+		t.Error("Expecting ext tree not to be modified")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"strconv"
+	"sync"
+)
+
+// StringOption returns the raw value of the option key, ok is false if the
+// option is not set.
+func (fi *FieldInfo) StringOption(key string) (string, bool) {
+	v, ok := fi.Options[key]
+	return v, ok
+}
+
+// IntOption returns the value of the option key parsed as int, ok is false if
+// the option is not set or can not be parsed.
+func (fi *FieldInfo) IntOption(key string) (int, bool) {
+	v, ok := fi.Options[key]
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// BoolOption returns the value of the option key parsed as bool, ok is false if
+// the option is not set or can not be parsed.  An option set without a value,
+// ex. "required", is true.
+func (fi *FieldInfo) BoolOption(key string) (bool, bool) {
+	v, ok := fi.Options[key]
+	if !ok {
+		return false, false
+	}
+	if v == "" {
+		return true, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
+
+// FloatOption returns the value of the option key parsed as float64, ok is
+// false if the option is not set or can not be parsed.
+func (fi *FieldInfo) FloatOption(key string) (float64, bool) {
+	v, ok := fi.Options[key]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// FieldOptions are the parsed values of the options commonly used for binding
+// and validation, options that are not set or can not be parsed have the zero
+// value.  Other options are available in FieldInfo.Options.
+type FieldOptions struct {
+	// Required is true if the "required" option is set to true or without
+	// a value.
+	Required bool
+	// Default is the value of the "default" option.
+	Default string
+	// Min is the value of the "min" option.
+	Min *float64
+	// Max is the value of the "max" option.
+	Max *float64
+	// MaxLen is the value of the "maxlen" option.
+	MaxLen *int
+	// Aliases are the values of the "alias" options, see FieldInfo.Aliases.
+	Aliases []string
+	// Skip is true if the "skip" option is set to true or without a value.
+	Skip bool
+}
+
+// parsedOptions caches the FieldOptions of a field.
+type parsedOptions struct {
+	once sync.Once
+	opts FieldOptions
+}
+
+// ParsedOptions returns the parsed options of the field.  The options are
+// parsed on the first call and cached with the field, so Options must not be
+// modified after that.  The returned FieldOptions are shared, they must not
+// be modified.
+func (fi *FieldInfo) ParsedOptions() FieldOptions {
+	if fi.parsed == nil {
+		return fi.parseOptions()
+	}
+	fi.parsed.once.Do(func() {
+		fi.parsed.opts = fi.parseOptions()
+	})
+	return fi.parsed.opts
+}
+
+func (fi *FieldInfo) parseOptions() FieldOptions {
+	var o FieldOptions
+	o.Required, _ = fi.BoolOption("required")
+	o.Default = fi.Options["default"]
+	if v, ok := fi.FloatOption("min"); ok {
+		o.Min = &v
+	}
+	if v, ok := fi.FloatOption("max"); ok {
+		o.Max = &v
+	}
+	if v, ok := fi.IntOption("maxlen"); ok {
+		o.MaxLen = &v
+	}
+	o.Aliases = fi.Aliases
+	o.Skip, _ = fi.BoolOption("skip")
+	return o
+}
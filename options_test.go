@@ -0,0 +1,98 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldInfoOptions(t *testing.T) {
+	type Foo struct {
+		Max int `http:"max,required,min=0,max=100,exact=false,name=foo,bad=x"`
+	}
+
+	m := NewMapper("http")
+	fi := m.TypeMap(reflect.TypeOf(Foo{})).GetByPath("max")
+
+	if v, ok := fi.IntOption("min"); !ok || v != 0 {
+		t.Errorf("Expecting %d, got %d (%v)", 0, v, ok)
+	}
+	if v, ok := fi.IntOption("max"); !ok || v != 100 {
+		t.Errorf("Expecting %d, got %d (%v)", 100, v, ok)
+	}
+	if _, ok := fi.IntOption("bad"); ok {
+		t.Errorf("Expecting parse failure")
+	}
+	if _, ok := fi.IntOption("none"); ok {
+		t.Errorf("Expecting missing option")
+	}
+
+	if v, ok := fi.FloatOption("max"); !ok || v != 100 {
+		t.Errorf("Expecting %v, got %v (%v)", 100, v, ok)
+	}
+	if _, ok := fi.FloatOption("bad"); ok {
+		t.Errorf("Expecting parse failure")
+	}
+	if _, ok := fi.FloatOption("none"); ok {
+		t.Errorf("Expecting missing option")
+	}
+
+	if v, ok := fi.BoolOption("required"); !ok || !v {
+		t.Errorf("Expecting %v, got %v (%v)", true, v, ok)
+	}
+	if v, ok := fi.BoolOption("exact"); !ok || v {
+		t.Errorf("Expecting %v, got %v (%v)", false, v, ok)
+	}
+	if _, ok := fi.BoolOption("bad"); ok {
+		t.Errorf("Expecting parse failure")
+	}
+
+	if v, ok := fi.StringOption("name"); !ok || v != "foo" {
+		t.Errorf("Expecting %s, got %s (%v)", "foo", v, ok)
+	}
+	if _, ok := fi.StringOption("none"); ok {
+		t.Errorf("Expecting missing option")
+	}
+}
+
+func TestParsedOptions(t *testing.T) {
+	type Foo struct {
+		Max   int    `http:"max,required,min=0,max=100,default=10"`
+		Name  string `http:"name,required=false,maxlen=32,alias=n,alias=title"`
+		Bad   int    `http:"bad,min=x,max=,maxlen=1.5,required=x"`
+		Skip  string `http:"skip,skip"`
+		Plain string `http:"plain"`
+	}
+
+	m := NewMapper("http")
+	tm := m.TypeMap(reflect.TypeOf(Foo{}))
+	f0, f100, i32 := 0.0, 100.0, 32
+
+	testCases := []struct {
+		name     string
+		expected FieldOptions
+	}{
+		{"max", FieldOptions{Required: true, Default: "10", Min: &f0, Max: &f100}},
+		{"name", FieldOptions{MaxLen: &i32, Aliases: []string{"n", "title"}}},
+		{"bad", FieldOptions{}},
+		{"skip", FieldOptions{Skip: true}},
+		{"plain", FieldOptions{}},
+	}
+	for _, tc := range testCases {
+		fi := tm.GetByPath(tc.name)
+		if o := fi.ParsedOptions(); !reflect.DeepEqual(o, tc.expected) {
+			t.Errorf("%s: Expecting %+v, got %+v", tc.name, tc.expected, o)
+		}
+		if o := fi.ParsedOptions(); !reflect.DeepEqual(o, tc.expected) {
+			t.Errorf("%s: Expecting cached %+v, got %+v", tc.name, tc.expected, o)
+		}
+	}
+
+	fi := &FieldInfo{Options: map[string]string{"required": ""}}
+	if o := fi.ParsedOptions(); !o.Required {
+		t.Errorf("Expecting required, got %+v", o)
+	}
+}
@@ -12,10 +12,16 @@
 package reflectx
 
 import (
+	"fmt"
+	"hash/fnv"
 	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // A FieldInfo is metadata for a struct field.
@@ -29,54 +35,473 @@ type FieldInfo struct {
 	Embedded bool
 	Children []*FieldInfo
 	Parent   *FieldInfo
+
+	// OptionOrder lists the option keys in the order they appear in the
+	// tags, if an option is repeated it's listed every time while Options
+	// has the last value.  Inherited options are not listed.
+	OptionOrder []string
+
+	// Aliases lists the values of the repeated "alias" option in order, ex.
+	// ["limit", "count"] for `http:"max,alias=limit,alias=count"`.  Name
+	// lookups, ex. FieldByName and TraversalsByName, match the aliases too,
+	// the aliases are not in Names.  A mapped name of any field takes
+	// precedence over an alias, if many fields have the same alias the one
+	// declared first wins.  Aliases of nested fields are matched with the
+	// path of the parent, like the "name" option in AliasMap.
+	Aliases []string
+
+	// Depth is the embedding depth of the field, it's len(Index), ex. 1 for
+	// the fields of the struct.
+	Depth int
+
+	// RawTag is the value of the mapper's tag as it's written in the struct,
+	// before the name and the options are parsed and before any tag
+	// transformation, ex. "max,default=10" for `http:"max,default=10"`.  It's
+	// empty if the field has none of the mapper's tags.
+	RawTag string
+
+	// parsed caches the result of ParsedOptions, it's nil for fields that
+	// are not built by a Mapper.
+	parsed *parsedOptions
+}
+
+// IsZero reports whether the field described by fi in the struct root (or
+// pointer to struct) is the zero value of its type.  A field behind a nil
+// pointer is zero.
+func (fi *FieldInfo) IsZero(root reflect.Value) bool {
+	v, ok := fieldByIndexesReadOnly(root, fi.Index)
+	return !ok || v.IsZero()
+}
+
+// SettableValue returns the field described by fi in the struct root (or
+// pointer to struct) for setting, nil pointers along the way are allocated.
+// It returns an error if the field can not be set, ex. because root is not
+// addressable or the field is unexported.
+func (fi *FieldInfo) SettableValue(root reflect.Value) (reflect.Value, error) {
+	v, err := FieldByIndexesAlloc(root, fi.Index)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("field %s not settable: %v", fi.Path, err)
+	}
+	if !v.CanSet() {
+		if !reflect.Indirect(root).CanAddr() {
+			return reflect.Value{}, fmt.Errorf("field %s not settable: root is not addressable", fi.Path)
+		}
+		return reflect.Value{}, fmt.Errorf("field %s not settable: field is unexported", fi.Path)
+	}
+	return v, nil
+}
+
+// IsPromoted reports whether the field is declared in an embedded struct and
+// promoted to the struct embedding it, as opposed to declared directly in its
+// struct.  Fields of nested (not embedded) struct fields are not promoted.  A
+// promoted field keeps its name if the embedded struct has no tag, if it has
+// a tag like `db:"base"` the field's path is prefixed with it.
+func (fi *FieldInfo) IsPromoted() bool {
+	return fi.Parent != nil && fi.Parent.Embedded
+}
+
+// FullName returns the Go field names from the root struct down to the field
+// joined with dots, eg. "RequestContext.SessionID".  Unlike Path it does not
+// depend on tags and it includes the names of embedded structs.
+func (fi *FieldInfo) FullName() string {
+	return strings.Join(fi.fieldNames(), ".")
 }
 
-// A StructMap is an index of field metadata for a struct.
+// fieldNames returns the Go field names from the root struct to fi.
+func (fi *FieldInfo) fieldNames() []string {
+	var names []string
+	for p := fi; p.Parent != nil; p = p.Parent {
+		names = append(names, p.Field.Name)
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return names
+}
+
+// A StructMap is an index of field metadata for a struct.  Index lists the
+// fields in struct declaration order, the fields of embedded and nested
+// structs follow the struct field, recursively.
 type StructMap struct {
 	Tree  *FieldInfo
 	Index []*FieldInfo
 	Paths map[string]*FieldInfo
 	Names map[string]*FieldInfo
+
+	// namesFold is Names with lowercased keys, it's set only if the mapping
+	// is case-insensitive.
+	namesFold map[string]*FieldInfo
+	// namesFoldLazy is namesFold built on the first call to NamesFold if the
+	// mapping is case-sensitive.
+	namesFoldLazy *lazyFoldIndex
+	// pathsFold is Paths with lowercased keys.
+	pathsFold map[string]*FieldInfo
+	// aliases indexes the fields by the "name" tag option.
+	aliases map[string]*FieldInfo
+	// nameAliases indexes the fields by the "alias" tag options.
+	nameAliases map[string]*FieldInfo
+	// goNames indexes the fields of the struct and the promoted fields by
+	// their Go names.
+	goNames map[string]*FieldInfo
+	// zeros are the zero values of the fields by name.
+	zeros map[string]reflect.Value
+	// columns are the traversals of the fields by name.
+	columns map[string][]int
+	// tagName is the first tag name of the mapper.
+	tagName string
+	// setters caches the setters returned by Mapper.Setter by name.
+	setters *sync.Map
+	// accessors caches the accessors returned by Mapper.Accessor by name.
+	accessors *sync.Map
+	// traversals caches the traversals returned by Mapper.CachedTraversals
+	// by names joined with NUL.
+	traversals *sync.Map
+	// err lists the invalid tags, it's set only if the mapping is strict.
+	err error
+	// flat is set if all the fields are direct fields of the struct that do
+	// not need to be allocated, so that they can be accessed with Field.
+	flat bool
+}
+
+// field returns the field described by fi in the struct v, see FieldByIndexes.
+func (f StructMap) field(v reflect.Value, fi *FieldInfo) reflect.Value {
+	if f.flat {
+		return v.Field(fi.Index[0])
+	}
+	return FieldByIndexes(v, fi.Index)
+}
+
+// readField is like field but it does not allocate nil pointers, it returns
+// false if the field is behind a nil pointer, see fieldByIndexesReadOnly.
+func (f StructMap) readField(v reflect.Value, fi *FieldInfo) (reflect.Value, bool) {
+	if f.flat {
+		return v.Field(fi.Index[0]), true
+	}
+	return fieldByIndexesReadOnly(v, fi.Index)
+}
+
+// lazyFoldIndex is an index with lowercased keys built once on demand.
+type lazyFoldIndex struct {
+	once  sync.Once
+	names map[string]*FieldInfo
+}
+
+// NamesFold returns the index of the fields by their lowercased mapped names,
+// the same fields as in Names, ex. to match names case-insensitively in a
+// custom way.  If two names differ only in case the field declared first
+// wins.  If the mapping is case-insensitive the index is the one built with
+// the mapping and used by the lookups, otherwise it's built on the first
+// call.  The returned map is shared and must not be modified.
+func (f StructMap) NamesFold() map[string]*FieldInfo {
+	if f.namesFold != nil {
+		return f.namesFold
+	}
+	if f.namesFoldLazy == nil {
+		return foldNames(&f)
+	}
+	f.namesFoldLazy.once.Do(func() {
+		f.namesFoldLazy.names = foldNames(&f)
+	})
+	return f.namesFoldLazy.names
+}
+
+// foldNames returns Names of f with lowercased keys, if two names differ only
+// in case the field declared first wins.
+func foldNames(f *StructMap) map[string]*FieldInfo {
+	names := make(map[string]*FieldInfo, len(f.Names))
+	f.Walk(func(fi *FieldInfo) bool {
+		if f.Names[fi.Path] != fi {
+			return true
+		}
+		key := strings.ToLower(fi.Path)
+		if _, ok := names[key]; !ok {
+			names[key] = fi
+		}
+		return true
+	})
+	return names
+}
+
+// lookup returns the *FieldInfo for a mapped name.
+func (f StructMap) lookup(name string) (*FieldInfo, bool) {
+	fi, ok := f.Names[name]
+	if !ok && f.namesFold != nil {
+		fi, ok = f.namesFold[strings.ToLower(name)]
+	}
+	if !ok {
+		fi, ok = f.nameAliases[name]
+	}
+	return fi, ok
+}
+
+// appendTraversals appends the traversals of names to dst, an empty int slice
+// for each name not found.
+func (f StructMap) appendTraversals(names []string, dst [][]int) [][]int {
+	for _, name := range names {
+		if fi, ok := f.lookup(name); ok {
+			dst = append(dst, fi.Index)
+		} else {
+			dst = append(dst, []int{})
+		}
+	}
+	return dst
 }
 
-// GetByPath returns a *FieldInfo for a given string path.
+// GetByPath returns a *FieldInfo for a given string path.  Names are used
+// verbatim in paths, so a name with the path separator ex. `db:"foo.bar"`
+// has the path "foo.bar" like field bar of a nested struct tagged
+// `db:"foo"`.  In such case the field with the separator in its name wins,
+// in Paths and in Names, because it's shallower, the nested field is shadowed
+// and can only be reached by its traversal.  Duplicates reports such fields.
 func (f StructMap) GetByPath(path string) *FieldInfo {
 	return f.Paths[path]
 }
 
+// GetByPathFold is like GetByPath but the path is matched case-insensitively,
+// ex. "Asset.Title" matches "asset.title".  An exact match is preferred, if
+// there is none the lowercased path is matched against the lowercased paths.
+// If two paths differ only in case the one declared first wins.
+func (f StructMap) GetByPathFold(path string) (*FieldInfo, bool) {
+	if fi, ok := f.Paths[path]; ok {
+		return fi, true
+	}
+	fi, ok := f.pathsFold[strings.ToLower(path)]
+	return fi, ok
+}
+
 // GetByTraversal returns a *FieldInfo for a given integer path.  It is
 // analogous to reflect.FieldByIndex, but using the cached traversal
 // rather than re-executing the reflect machinery each time.
 func (f StructMap) GetByTraversal(index []int) *FieldInfo {
-	if len(index) == 0 {
-		return nil
+	fi, _ := f.GetByTraversalOK(index)
+	return fi
+}
+
+// GetByTraversalOK is like GetByTraversal but it also returns false if the
+// traversal is empty or it does not exist in the struct.  It never panics,
+// so it's safe to use with traversals from untrusted sources.
+func (f StructMap) GetByTraversalOK(index []int) (*FieldInfo, bool) {
+	if len(index) == 0 || f.Tree == nil {
+		return nil, false
 	}
 
 	tree := f.Tree
 	for _, i := range index {
-		if i >= len(tree.Children) || tree.Children[i] == nil {
-			return nil
+		if i < 0 || i >= len(tree.Children) || tree.Children[i] == nil {
+			return nil, false
 		}
 		tree = tree.Children[i]
 	}
-	return tree
+	return tree, true
+}
+
+// GetByField returns the *FieldInfo for a struct field obtained with the
+// reflect package ex. with reflect.Type.Field or reflect.Type.FieldByName,
+// the field is matched by its Index.  It returns false if the field is not
+// mapped or if it's a field of a different type.
+func (f StructMap) GetByField(field reflect.StructField) (*FieldInfo, bool) {
+	fi, ok := f.GetByTraversalOK(field.Index)
+	if !ok || fi.Field.Name != field.Name || fi.Field.Type != field.Type {
+		return nil, false
+	}
+	return fi, true
+}
+
+// Walk calls fn for every *FieldInfo in the tree in depth-first order,
+// following struct declaration order.  Embedded structs and nested struct
+// fields are visited as a node before their children, so promoted fields of an
+// embedded struct are visited right after the embedded field itself.  Walk
+// stops when fn returns false.
+func (f StructMap) Walk(fn func(fi *FieldInfo) bool) {
+	walk(f.Tree, fn)
+}
+
+func walk(tree *FieldInfo, fn func(fi *FieldInfo) bool) bool {
+	for _, fi := range tree.Children {
+		if fi == nil {
+			continue
+		}
+		if !fn(fi) || !walk(fi, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Embeds returns the fields that are struct parents, i.e. fields with
+// Children, in the order of Walk.  Anonymous embedded structs and struct
+// fields with the inline option have Embedded set, nested struct fields are
+// returned too but have Embedded unset.  Leaf types and recursive fields
+// have no Children and are not returned.
+func (f StructMap) Embeds() []*FieldInfo {
+	var embeds []*FieldInfo
+	f.Walk(func(fi *FieldInfo) bool {
+		if len(fi.Children) != 0 {
+			embeds = append(embeds, fi)
+		}
+		return true
+	})
+	return embeds
+}
+
+// Fingerprint returns a hash of the mapping, ex. to reuse artifacts like
+// prepared statements for types generated at runtime with the same mapping.
+// It hashes the mapped name, the path, the kind and the options of every
+// field in order, so two mappings with the same names, kinds and options have
+// the same fingerprint even if they are mappings of different types.  Go
+// field names and types other than their kind are not considered.
+func (f StructMap) Fingerprint() uint64 {
+	h := fnv.New64a()
+	for _, fi := range f.Index {
+		fmt.Fprintf(h, "%s\x00%s\x00%s", fi.Name, fi.Path, fi.Field.Type.Kind())
+		keys := make([]string, 0, len(fi.Options))
+		for k := range fi.Options {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "\x00%s=%s", k, fi.Options[k])
+		}
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
+
+// String returns a human readable listing of the mapped names sorted by path,
+// useful for debugging.  Every line contains the path, the traversal, the field
+// type and options, embedded structs are marked with "embedded".
+func (f StructMap) String() string {
+	var fields []*FieldInfo
+	for _, fi := range f.Paths {
+		if _, ok := f.Names[fi.Path]; ok || fi.Embedded {
+			fields = append(fields, fi)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Path < fields[j].Path
+	})
+
+	var b strings.Builder
+	for _, fi := range fields {
+		kind := "field"
+		if fi.Embedded {
+			kind = "embedded"
+		}
+		fmt.Fprintf(&b, "%s %v %s %s %v\n", fi.Path, fi.Index, fi.Field.Type, kind, fi.Options)
+	}
+	return b.String()
 }
 
 // Mapper is a general purpose mapper of names to struct fields.  A Mapper
 // behaves like most marshallers in the standard library, obeying a field tag
 // for name mapping but also providing a basic transform function.
+//
+// Methods taking a reflect.Value accept a struct or a pointer to a struct.  A
+// nil pointer has no fields for the methods that only read the fields, ex.
+// FieldMap returns an empty map and FieldByPath returns zero Value, they
+// never allocate it.  It's allocated by the methods that set or allocate
+// fields, ex. FieldByNameAlloc, if it's settable i.e. it's addressable like
+// reflect.ValueOf(&p).Elem().  Methods that allocate panic for a nil pointer
+// that is not settable.
 type Mapper struct {
-	cache      sync.Map // map[reflect.Type]*StructMap
-	tagName    string
-	tagMapFunc func(string) string
-	mapFunc    func(string) string
+	cache     sync.Map // map[reflect.Type]*typeMapEntry
+	tagCache  sync.Map // map[tagMapKey]*typeMapEntry, see TypeMapTag
+	lazyCache sync.Map // map[reflect.Type]*lazyMapEntry, see LazyTypeMap
+	lru       *typeLRU // nil if the cache is unbounded
+
+	// mu guards opts, mappings are built with a read lock held so that
+	// changing a setting and clearing the cache is atomic.
+	mu     sync.RWMutex
+	opts   mapOptions
+	onMiss func(t reflect.Type)
+
+	// closed is set by Close, mappings are not cached once it's set.
+	closed atomic.Bool
+}
+
+// mapOptions are the Mapper settings used to build mappings.
+type mapOptions struct {
+	tagNames        []string
+	tagMapFunc      mapf
+	mapFunc         mapf
+	caseInsensitive bool
+	pathSeparator   string
+	flattenSep      string
+	unexported      bool
+	optionsTag      string
+	leafTypes       map[reflect.Type]bool
+	skipFunc        func(f reflect.StructField) bool
+	trimTagSpace    bool
+	strictTags      bool
+	inherit         map[string]bool
+	requireTag      bool
+	noPromote       bool
+	skipUntagged    bool
+	jsonFallback    bool
+	validateOptions bool
+	nameDelim       rune
+	stripPrefix     string
+	normalizers     []func(string) string
+	enums           enumLabels
+	setters         map[reflect.Type]SetterFunc
+	bools           map[string]bool
+	pathFunc        func(parents []string, field string) string
+}
+
+// timeType is mapped as a leaf by default.
+var timeType = reflect.TypeOf(time.Time{})
+
+// isLeaf reports whether the struct type t is mapped as a single field.
+func (o *mapOptions) isLeaf(t reflect.Type) bool {
+	return t == timeType || o.leafTypes[t]
+}
+
+// separator returns the path separator, "." by default.
+func (o *mapOptions) separator() string {
+	if o.pathSeparator == "" {
+		return "."
+	}
+	return o.pathSeparator
+}
+
+// flattenSeparator returns the separator of flattened struct fields, "_" by
+// default.
+func (o *mapOptions) flattenSeparator() string {
+	if o.flattenSep == "" {
+		return "_"
+	}
+	return o.flattenSep
 }
 
 // NewMapper returns a new mapper using the tagName as its struct field tag.
 // If tagName is the empty string, it is ignored.
 func NewMapper(tagName string) *Mapper {
+	return NewMapperTags(tagNames(tagName)...)
+}
+
+// NewMapperTags returns a new mapper using the tagNames as its struct field
+// tags in priority order.  For every field the first tag that is set is used
+// to derive the field's name, a tag with a non-empty value takes precedence
+// over an empty one.  Fields with none of the tags are mapped by their names.
+func NewMapperTags(tagNames ...string) *Mapper {
+	return &Mapper{
+		opts: mapOptions{
+			tagNames: tagNames,
+		},
+	}
+}
+
+// NewMapperWithOptionsTag returns a new mapper using the nameTag as its struct
+// field tag for names and the optionsTag for options, ex. with "http" and
+// "reflectx" a field tagged `http:"max" reflectx:"required,min=0"` is named
+// "max" and has "required" and "min" options.  Options can still be set in
+// the nameTag, the ones in the optionsTag take precedence.
+func NewMapperWithOptionsTag(nameTag, optionsTag string) *Mapper {
 	return &Mapper{
-		tagName: tagName,
+		opts: mapOptions{
+			tagNames:   tagNames(nameTag),
+			optionsTag: optionsTag,
+		},
 	}
 }
 
@@ -85,98 +510,1700 @@ func NewMapper(tagName string) *Mapper {
 // have values like "name,omitempty".
 func NewMapperTagFunc(tagName string, mapFunc, tagMapFunc func(string) string) *Mapper {
 	return &Mapper{
-		tagName:    tagName,
-		mapFunc:    mapFunc,
-		tagMapFunc: tagMapFunc,
+		opts: mapOptions{
+			tagNames:   tagNames(tagName),
+			mapFunc:    mapFunc,
+			tagMapFunc: tagMapFunc,
+		},
+	}
+}
+
+// NewMapperFunc returns a new mapper which optionally obeys a field tag and
+// a struct field name mapper func given by f.  Tags will take precedence, but
+// for any other field, the mapped name will be f(field.Name)
+func NewMapperFunc(tagName string, f func(string) string) *Mapper {
+	return &Mapper{
+		opts: mapOptions{
+			tagNames: tagNames(tagName),
+			mapFunc:  f,
+		},
+	}
+}
+
+// NewMapperPathFunc returns a new mapper which optionally obeys a field tag
+// and a struct field path mapper func given by f.  Tags will take precedence,
+// but for any other field the mapped path will be f(parents, field.Name)
+// where parents are the Go names of the enclosing struct fields, including
+// the embedded ones, ex. the Name field of an embedded Parent struct gives
+// f([]string{"Parent"}, "Name").  The result is used as the full path of the
+// field, it is not joined with the parent path.
+func NewMapperPathFunc(tagName string, f func(parents []string, field string) string) *Mapper {
+	return &Mapper{
+		opts: mapOptions{
+			tagNames: tagNames(tagName),
+			pathFunc: f,
+		},
+	}
+}
+
+// SetCaseInsensitive enables or disables case-insensitive name matching in
+// FieldByName, FieldsByName and TraversalsByName.  An exact match is always
+// preferred, if there is none the name is matched against the lowercased
+// names.  If two names differ only in case the one declared first wins.
+// Changing the setting clears the cache.
+func (m *Mapper) SetCaseInsensitive(v bool) {
+	m.update(func(o *mapOptions) {
+		o.caseInsensitive = v
+	})
+}
+
+// SetPathSeparator sets the separator used to join names in FieldInfo.Path, the
+// default is ".".  Changing the setting clears the cache.
+func (m *Mapper) SetPathSeparator(sep string) {
+	m.update(func(o *mapOptions) {
+		o.pathSeparator = sep
+	})
+}
+
+// SetFlattenSeparator sets the separator used to join the name of a struct
+// field with the "flatten" tag option and the names of its fields, the
+// default is "_", ex. the Street field of a struct field tagged
+// `http:"addr,flatten"` has path "addr_street" instead of "addr.street".
+// Changing the setting clears the cache.
+func (m *Mapper) SetFlattenSeparator(sep string) {
+	m.update(func(o *mapOptions) {
+		o.flattenSep = sep
+	})
+}
+
+// IncludeUnexported enables or disables mapping of unexported fields that have
+// a tag.  Such fields can not be set, and their values can not be obtained
+// with Interface, using the reflect.Values returned by FieldMapAlloc, FieldByName
+// and other methods, those methods panic if they need to allocate a nil
+// pointer to reach a field behind an unexported field.  Use
+// FieldByIndexesUnsafe to get settable values for them.  It's off by default.
+// Changing the setting clears the cache.
+func (m *Mapper) IncludeUnexported(v bool) {
+	m.update(func(o *mapOptions) {
+		o.unexported = v
+	})
+}
+
+// SetSkipFunc sets a function deciding which fields are not mapped, if fn
+// returns true for a field it's skipped, for an embedded struct its fields
+// are skipped as well.  It complements the "-" tag, fn is not called for
+// fields skipped with it.  Changing the setting clears the cache.
+func (m *Mapper) SetSkipFunc(fn func(f reflect.StructField) bool) {
+	m.update(func(o *mapOptions) {
+		o.skipFunc = fn
+	})
+}
+
+// TreatAsLeaf makes the mapper map fields of the struct types as single fields
+// instead of recursing into them, time.Time is always treated as a leaf.
+// Pointers to the types are leaves too.  Changing the setting clears the
+// cache.
+func (m *Mapper) TreatAsLeaf(types ...reflect.Type) {
+	m.update(func(o *mapOptions) {
+		leafTypes := make(map[reflect.Type]bool, len(o.leafTypes)+len(types))
+		for t := range o.leafTypes {
+			leafTypes[t] = true
+		}
+		for _, t := range types {
+			leafTypes[Deref(t)] = true
+		}
+		o.leafTypes = leafTypes
+	})
+}
+
+// NameDelimiter sets the delimiter of the segments of tags shared with other
+// libraries, only the first segment is parsed as the name and options and the
+// rest is ignored, ex. with ';' a field tagged `http:"max,omitempty;json:max"`
+// is named "max" and has option "omitempty".  The zero rune disables it, it's
+// disabled by default.  Changing the setting clears the cache.
+func (m *Mapper) NameDelimiter(r rune) {
+	m.update(func(o *mapOptions) {
+		o.nameDelim = r
+	})
+}
+
+// StripPrefix sets a namespace prefix removed from tags before the name and
+// options are parsed, ex. with "req:" a field tagged `http:"req:max,min=1"` is
+// named "max" and has option "min".  Tags without the prefix are used as is.
+// The empty prefix disables it, it's disabled by default.  Changing the
+// setting clears the cache.
+func (m *Mapper) StripPrefix(prefix string) {
+	m.update(func(o *mapOptions) {
+		o.stripPrefix = prefix
+	})
+}
+
+// TrimTagSpace enables or disables trimming of leading and trailing spaces of
+// the names and the option keys and values parsed from tags, ex. with it
+// enabled `http:"max , x"` is named "max" and has option "x".  It's off by
+// default.  Changing the setting clears the cache.
+func (m *Mapper) TrimTagSpace(v bool) {
+	m.update(func(o *mapOptions) {
+		o.trimTagSpace = v
+	})
+}
+
+// StrictTags enables or disables checking of the tags when building mappings,
+// the problems are reported by TypeMapE.  A tag is invalid if it has an
+// option with an empty key ex. `http:"max,,=1"`, an unterminated quote ex.
+// `http:"s,oneof='a,b"`, if it has the same name as another field of the
+// same struct, or if its name has the path separator and it shadows the path
+// of a nested field, see GetByPath.  Fields without tags that the name func
+// maps to the same name are reported too.  It's off by default.  Changing
+// the setting clears the cache.
+func (m *Mapper) StrictTags(v bool) {
+	m.update(func(o *mapOptions) {
+		o.strictTags = v
+	})
+}
+
+// ValidateOptions enables or disables checking of the kinds of the fields
+// with validation options when building mappings, the problems are reported
+// by TypeMapE.  The min and max options are valid only for numeric fields and
+// the maxlen option only for string and slice fields, ex. `http:"s,max=10"`
+// on a string field is invalid.  Other options are not checked.  It's off by
+// default.  Changing the setting clears the cache.
+func (m *Mapper) ValidateOptions(v bool) {
+	m.update(func(o *mapOptions) {
+		o.validateOptions = v
+	})
+}
+
+// PromoteEmbedded enables or disables promotion of the fields of embedded
+// structs, if it's disabled the fields are mapped with the path of the
+// embedded struct like fields of nested structs, ex. the SessionID field of
+// an embedded RequestContext struct is mapped as "RequestContext.sid" and not
+// as "sid".  Structs with the inline option are promoted regardless.  It's
+// enabled by default.  Changing the setting clears the cache.
+func (m *Mapper) PromoteEmbedded(v bool) {
+	m.update(func(o *mapOptions) {
+		o.noPromote = !v
+	})
+}
+
+// FallbackToJSON enables or disables naming of fields that have none of the
+// mapper's tags by the name in the json tag, ex. a field tagged
+// `json:"user_id,omitempty"` is named "user_id".  Like in encoding/json
+// fields tagged `json:"-"` are skipped and fields with an empty json name,
+// ex. `json:",omitempty"`, are named as if they had no tag.  The json options
+// are not parsed as options.  It's off by default.  Changing the setting
+// clears the cache.
+func (m *Mapper) FallbackToJSON(v bool) {
+	m.update(func(o *mapOptions) {
+		o.jsonFallback = v
+	})
+}
+
+// PromoteUntaggedEmbeds enables or disables mapping of embedded structs
+// without a tag, if it's disabled such structs and their fields are not
+// mapped, embedded structs with a tag are mapped with the tag as the path
+// prefix of their fields.  An empty tag, ex. `db:""`, promotes the fields like
+// an embedded struct without a tag when it's enabled.  It's enabled by
+// default.  Changing the setting clears the cache.
+func (m *Mapper) PromoteUntaggedEmbeds(v bool) {
+	m.update(func(o *mapOptions) {
+		o.skipUntagged = !v
+	})
+}
+
+// RequireTag enables or disables mapping only the fields that have one of the
+// mapper's tags, fields without a tag are skipped instead of being mapped by
+// their names.  This is useful for binding, so that only the explicitly
+// exposed fields can be set.  Embedded structs without a tag are still mapped
+// so that their tagged fields are promoted.  It's off by default.  Changing
+// the setting clears the cache.
+func (m *Mapper) RequireTag(v bool) {
+	m.update(func(o *mapOptions) {
+		o.requireTag = v
+	})
+}
+
+// SetInheritableOptions sets the tag options that fields inherit from the
+// struct field they are declared in, embedded or not, unless they have the
+// option set themselves, ex. with "prefix" the fields of an embedded struct
+// tagged `http:"ctx,prefix=c_"` have option prefix "c_".  Options are
+// inherited recursively.  By default no options are inherited.  Changing the
+// setting clears the cache.
+func (m *Mapper) SetInheritableOptions(keys ...string) {
+	m.update(func(o *mapOptions) {
+		o.inherit = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			o.inherit[k] = true
+		}
+	})
+}
+
+// AddNameNormalizer appends fn to the functions applied in order to the
+// resolved name of every field, tagged or not, ex. adding strings.TrimSpace
+// and strings.ToLower maps a field tagged `http:" Max"` as "max".  Unlike
+// NameFunc the functions compose and they apply to tag names too.  The names
+// are normalized after the other settings, ex. StripPrefix, are applied.
+// Changing the setting clears the cache.
+func (m *Mapper) AddNameNormalizer(fn func(string) string) {
+	m.update(func(o *mapOptions) {
+		// copy so that clones sharing the slice are not affected
+		o.normalizers = append(o.normalizers[:len(o.normalizers):len(o.normalizers)], fn)
+	})
+}
+
+// TagName returns the struct field tag the mapper reads names from, if the
+// mapper reads many tags, see NewMapperTags, it returns the one with the
+// highest priority.  It returns the empty string if tags are ignored.
+func (m *Mapper) TagName() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.opts.tagNames) == 0 {
+		return ""
+	}
+	return m.opts.tagNames[0]
+}
+
+// TagNames returns the struct field tags the mapper reads names from in
+// priority order.
+func (m *Mapper) TagNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.opts.tagNames...)
+}
+
+// NameFunc returns the function mapping the names of fields without tags, see
+// NewMapperFunc, it's nil if the field names are used as they are.
+func (m *Mapper) NameFunc() func(string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.opts.mapFunc
+}
+
+// Clone returns a new mapper with the same settings as m, changing the
+// settings of one of them does not affect the other.  The clone starts with an
+// empty cache, it does not inherit the cached mappings of m because they may
+// be different once its settings are changed.  If m has a bounded cache the
+// clone has a cache of the same size.
+func (m *Mapper) Clone() *Mapper {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := &Mapper{
+		opts:   m.opts,
+		onMiss: m.onMiss,
+	}
+	c.opts.tagNames = append([]string(nil), m.opts.tagNames...)
+	if m.opts.leafTypes != nil {
+		c.opts.leafTypes = make(map[reflect.Type]bool, len(m.opts.leafTypes))
+		for t := range m.opts.leafTypes {
+			c.opts.leafTypes[t] = true
+		}
+	}
+	if m.lru != nil {
+		c.lru = newTypeLRU(m.lru.maxEntries)
+	}
+	return c
+}
+
+// update applies fn to the settings and clears the cache so that new
+// mappings are built with the new settings.
+func (m *Mapper) update(fn func(o *mapOptions)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.opts)
+	m.ClearCache()
+}
+
+// TypeMap returns a mapping of field strings to int slices representing
+// the traversal down the struct to reach the field.  Pointer types are
+// dereferenced, a struct type and pointers to it share the cached mapping.
+func (m *Mapper) TypeMap(t reflect.Type) *StructMap {
+	t = Deref(t)
+	if m.closed.Load() {
+		return m.buildUncached(t)
+	}
+	v, ok := m.cache.Load(t)
+	if !ok {
+		v, _ = m.cache.LoadOrStore(t, &typeMapEntry{})
+		// Close may have cleared the cache before the entry was stored, a
+		// closed mapper would never drop it
+		if m.closed.Load() {
+			m.cache.CompareAndDelete(t, v)
+			return m.buildUncached(t)
+		}
+	}
+
+	e := v.(*typeMapEntry)
+	if m.lru != nil {
+		m.lru.touch(t, e, m.isCached, m.evict)
+	}
+
+	var onMiss func(t reflect.Type)
+	e.once.Do(func() {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		e.mapping = getMapping(t, &m.opts)
+		onMiss = m.onMiss
+	})
+	if onMiss != nil {
+		onMiss(t)
+	}
+	return e.mapping
+}
+
+// buildUncached builds the mapping of t without caching it.
+func (m *Mapper) buildUncached(t reflect.Type) *StructMap {
+	m.mu.RLock()
+	mapping := getMapping(t, &m.opts)
+	onMiss := m.onMiss
+	m.mu.RUnlock()
+
+	if onMiss != nil {
+		onMiss(t)
+	}
+	return mapping
+}
+
+// tagMapKey is the key of a mapping built by TypeMapTag.
+type tagMapKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// TypeMapTag is like TypeMap but the mapping is built using tag instead of
+// the tag names of the mapper, ex. to map a type by its "json" tags with a
+// mapper of "db" tags, all the other settings of the mapper are used.  The
+// mappings are cached by type and tag apart from the mappings returned by
+// TypeMap, even if tag is the tag name of the mapper, they are not subject
+// to the bound of the cache, see NewMapperLRU, but they are dropped
+// by ClearCache and InvalidateType.  The empty tag maps the fields by their
+// names only.
+func (m *Mapper) TypeMapTag(t reflect.Type, tag string) *StructMap {
+	t = Deref(t)
+	build := func() (*StructMap, func(t reflect.Type)) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		opts := m.opts
+		opts.tagNames = tagNames(tag)
+		return getMapping(t, &opts), m.onMiss
+	}
+	if m.closed.Load() {
+		mapping, onMiss := build()
+		if onMiss != nil {
+			onMiss(t)
+		}
+		return mapping
+	}
+
+	key := tagMapKey{t: t, tag: tag}
+	v, ok := m.tagCache.Load(key)
+	if !ok {
+		v, _ = m.tagCache.LoadOrStore(key, &typeMapEntry{})
+		// see TypeMap
+		if m.closed.Load() {
+			m.tagCache.CompareAndDelete(key, v)
+			return m.TypeMapTag(t, tag)
+		}
+	}
+
+	var onMiss func(t reflect.Type)
+	e := v.(*typeMapEntry)
+	e.once.Do(func() {
+		e.mapping, onMiss = build()
+	})
+	if onMiss != nil {
+		onMiss(t)
+	}
+	return e.mapping
+}
+
+// OnCacheMiss sets a function called by TypeMap when the mapping of a type
+// is built because it's not cached, ex. to log the types that are not
+// registered with RegisterTypes, in that case set it after registering the
+// types.  It's called once for every built mapping
+// even if the type is mapped concurrently, after the cache is cleared or the
+// type is invalidated it's called again.  It's called after the mapping is
+// cached so fn may use the mapper.
+func (m *Mapper) OnCacheMiss(fn func(t reflect.Type)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onMiss = fn
+}
+
+// AliasMap returns the fields of t that have an alternative name set with
+// the "name" tag option indexed by the alternative name, ex. a field tagged
+// `http:"default,name=alt"` is mapped as "default" and it's indexed as "alt".
+// Nested fields are indexed by the path with the field's name replaced by the
+// alternative name.  If many fields have the same alternative name the one
+// declared first wins.  The returned map is shared and must not be modified.
+func (m *Mapper) AliasMap(t reflect.Type) map[string]*FieldInfo {
+	return m.TypeMap(t).aliases
+}
+
+// PrefixedNames returns StructMap.Names of t with prefix prepended to every
+// name, ex. "inner_sid" for "sid" with prefix "inner_", to flatten the names
+// of a type mapped as a part of another type at the call site.  The returned
+// map is new, the cached mapping is not changed, the *FieldInfo values are
+// shared and must not be modified.
+func (m *Mapper) PrefixedNames(t reflect.Type, prefix string) map[string]*FieldInfo {
+	names := m.TypeMap(t).Names
+	r := make(map[string]*FieldInfo, len(names))
+	for name, fi := range names {
+		r[prefix+name] = fi
+	}
+	return r
+}
+
+// Ambiguities returns the names of t that are not mapped because they are
+// ambiguous, that is many fields at the same, shallowest depth have the name,
+// ex. the ID fields of two embedded structs.  For every such name it returns
+// the full Go names of the conflicting fields as returned by
+// FieldInfo.FullName, in declaration order.
+func (m *Mapper) Ambiguities(t reflect.Type) map[string][]string {
+	tm := m.TypeMap(t)
+
+	depth := map[string]int{}
+	r := map[string][]string{}
+	for _, fi := range tm.Index {
+		if fi.Name == "" || fi.Embedded {
+			continue
+		}
+		if _, ok := tm.Names[fi.Path]; ok {
+			continue
+		}
+		d, ok := depth[fi.Path]
+		switch {
+		case !ok || fi.Depth < d:
+			depth[fi.Path] = fi.Depth
+			r[fi.Path] = []string{fi.FullName()}
+		case fi.Depth == d:
+			r[fi.Path] = append(r[fi.Path], fi.FullName())
+		}
+	}
+	return r
+}
+
+// Duplicates returns the names of t that are used by many fields of the same
+// struct, ex. two fields tagged `db:"id"`, such names are almost always a
+// mistake.  Unlike in Ambiguities fields promoted from different embedded
+// structs are not duplicates.  For every such name it returns the full Go
+// names of the fields as returned by FieldInfo.FullName, in declaration
+// order.  Paths of nested fields shadowed by names with the path separator,
+// see GetByPath, are reported too, the shadowing field is listed first.  Use
+// it in tests to catch duplicate tags, or see StrictTags.
+func (m *Mapper) Duplicates(t reflect.Type) map[string][]string {
+	tm := m.TypeMap(t)
+
+	r := map[string][]string{}
+	check := func(fi *FieldInfo) {
+		for _, fields := range duplicateChildren(fi) {
+			for _, c := range fields {
+				r[c.Path] = append(r[c.Path], c.FullName())
+			}
+		}
+	}
+	check(tm.Tree)
+	tm.Walk(func(fi *FieldInfo) bool {
+		check(fi)
+		return true
+	})
+	m.mu.RLock()
+	sep := m.opts.separator()
+	m.mu.RUnlock()
+	for _, p := range shadowedPaths(tm.Index, sep) {
+		r[p[0].Path] = append(r[p[0].Path], p[1].FullName(), p[0].FullName())
+	}
+	return r
+}
+
+// FieldInfoByGoName returns the field of t with the Go field name goName
+// regardless of its tag, ex. "MaxResults" for a field tagged `http:"max"`.
+// Fields promoted from embedded structs are found by their names following
+// the Go rules, the shallowest field wins and ambiguous names are not found.
+// Fields of nested, not embedded, structs are not found.
+func (m *Mapper) FieldInfoByGoName(t reflect.Type, goName string) (*FieldInfo, bool) {
+	fi, ok := m.TypeMap(t).goNames[goName]
+	return fi, ok
+}
+
+// FieldType returns the type of the field of t with the mapped name, ex. to
+// choose a parser for a value before binding it, without a value of t.  The
+// name is looked up like in FieldByName.  It returns false if the name is not
+// found.
+func (m *Mapper) FieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	fi, ok := m.TypeMap(t).lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return fi.Field.Type, true
+}
+
+// RegisterTypes builds and caches the mappings of types, so that the first
+// TypeMap call for any of them is a cache hit.  The mappings are built
+// concurrently, pointer types are dereferenced like in FieldMap and other
+// methods taking a reflect.Value.  Types that are already cached are not
+// mapped again.  It is safe to call RegisterTypes from init or concurrently
+// with other methods.
+func (m *Mapper) RegisterTypes(types ...reflect.Type) {
+	var wg sync.WaitGroup
+	for _, t := range types {
+		wg.Add(1)
+		go func(t reflect.Type) {
+			defer wg.Done()
+			m.TypeMap(Deref(t))
+		}(t)
+	}
+	wg.Wait()
+}
+
+// ExportCache returns the types that have cached mappings sorted by their
+// string representation.  Types can not be persisted across processes, the
+// intended workflow is to persist an identity of the types, ex. their
+// String, at shutdown and on the next start pass the matching known types of
+// the program to WarmFrom, so that the mappings are built at startup and not
+// on first use.
+func (m *Mapper) ExportCache() []reflect.Type {
+	var types []reflect.Type
+	m.cache.Range(func(key, _ interface{}) bool {
+		types = append(types, key.(reflect.Type))
+		return true
+	})
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+	return types
+}
+
+// WarmFrom builds and caches the mappings of types like RegisterTypes, ex. of
+// the types matching the ones returned by ExportCache in a previous run.
+func (m *Mapper) WarmFrom(types []reflect.Type) {
+	m.RegisterTypes(types...)
+}
+
+// Names returns the mapped names of t in struct declaration order, names
+// promoted from embedded structs are listed in the position of the embedded
+// struct.  The order is stable and does not depend on map iteration.
+func (m *Mapper) Names(t reflect.Type) []string {
+	tm := m.TypeMap(t)
+	names := make([]string, 0, len(tm.Names))
+	tm.Walk(func(fi *FieldInfo) bool {
+		if tm.Names[fi.Path] == fi {
+			names = append(names, fi.Path)
+		}
+		return true
+	})
+	return names
+}
+
+// LeafNames is like Names but it lists only the names of the leaf fields,
+// the fields without Children, ex. to list the columns of an UPDATE
+// statement.  Nested structs and embedded structs with a tag are not listed,
+// their fields are, types treated as leaves ex. time.Time are listed.  The
+// names are in struct declaration order.
+func (m *Mapper) LeafNames(t reflect.Type) []string {
+	tm := m.TypeMap(t)
+	names := make([]string, 0, len(tm.Names))
+	tm.Walk(func(fi *FieldInfo) bool {
+		if tm.Names[fi.Path] == fi && len(fi.Children) == 0 {
+			names = append(names, fi.Path)
+		}
+		return true
+	})
+	return names
+}
+
+// HasMappedFields reports whether the mapping of t has any names, ex. to
+// fail early for a struct without exported or tagged fields.  It's false for
+// types that are not Mappable, their mappings are not built.
+func (m *Mapper) HasMappedFields(t reflect.Type) bool {
+	if !Mappable(t) {
+		return false
+	}
+	return len(m.TypeMap(t).Names) != 0
+}
+
+// ZeroMap returns the zero values of the mapped fields of t by name, they can
+// be assigned to the fields of a reused value to reset it, ex. with the
+// values returned by FieldMap.  The map and the values are shared, they must
+// not be modified, the values are not settable.
+func (m *Mapper) ZeroMap(t reflect.Type) map[string]reflect.Value {
+	return m.TypeMap(t).zeros
+}
+
+// ColumnIndex returns the traversals of the mapped fields of t by name, ex.
+// to find the destination fields of the columns of a result set.  Unlike
+// TraversalsByName it does not need to look up every name, and unlike
+// StructMap.Names the traversals are available without the *FieldInfo
+// indirection.  The map and the traversals are shared, they must not be
+// modified.
+func (m *Mapper) ColumnIndex(t reflect.Type) map[string][]int {
+	return m.TypeMap(t).columns
+}
+
+// Close clears the cache and stops caching, the mapper remains usable but
+// every TypeMap call, and every method using it, builds the mapping of the
+// type from scratch, ex. so that transient mappers in tests do not hold the
+// mappings.  The mapper does not run background goroutines, Close does not
+// wait for anything.  Calling Close again is a no-op, it always returns nil.
+// Clones of a closed mapper are not closed.
+func (m *Mapper) Close() error {
+	if m.closed.Swap(true) {
+		return nil
+	}
+	m.ClearCache()
+	return nil
+}
+
+// ClearCache drops all the cached mappings, the next TypeMap call for any type
+// builds its mapping from scratch.  StructMaps returned before remain valid.
+// It is safe to call ClearCache concurrently with other methods.
+func (m *Mapper) ClearCache() {
+	// the types are untracked first so that an entry stored while the caches
+	// are cleared is either dropped by Range or tracked by TypeMap after
+	if m.lru != nil {
+		m.lru.clear()
+	}
+	m.cache.Range(func(key, _ interface{}) bool {
+		m.cache.Delete(key)
+		return true
+	})
+	m.tagCache.Range(func(key, _ interface{}) bool {
+		m.tagCache.Delete(key)
+		return true
+	})
+	m.lazyCache.Range(func(key, _ interface{}) bool {
+		m.lazyCache.Delete(key)
+		return true
+	})
+}
+
+// TypeMapE is like TypeMap but if the mapper is strict, see StrictTags, it
+// returns an error listing the invalid tags of t, the error identifies the
+// fields by FieldInfo.FullName.  The error includes the fields without tags
+// that the name func, see NewMapperFunc, maps to the same name as another
+// field of the same struct, ex. UserID and Userid with strings.ToLower, the
+// name is ambiguous and neither of them is found by it.  Call it for every
+// type after RegisterTypes to catch invalid tags at startup.  The mapping is
+// returned even if there is an error.
+func (m *Mapper) TypeMapE(t reflect.Type) (*StructMap, error) {
+	tm := m.TypeMap(t)
+	return tm, tm.err
+}
+
+// InvalidateType drops the cached mapping of t, the next TypeMap call for t
+// builds its mapping from scratch.  StructMaps returned before remain valid.
+// It is safe to call InvalidateType concurrently with other methods.
+func (m *Mapper) InvalidateType(t reflect.Type) {
+	t = Deref(t)
+	if m.lru != nil {
+		m.lru.remove(t)
+	}
+	m.drop(t)
+	m.lazyCache.Delete(t)
+	m.tagCache.Range(func(key, _ interface{}) bool {
+		if key.(tagMapKey).t == t {
+			m.tagCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// drop removes the cached mapping of t, the cached traversals are dropped
+// with it.
+func (m *Mapper) drop(t reflect.Type) {
+	m.cache.Delete(t)
+}
+
+// isCached reports whether e is the cached entry of t.
+func (m *Mapper) isCached(t reflect.Type, e *typeMapEntry) bool {
+	v, ok := m.cache.Load(t)
+	return ok && v == e
+}
+
+// evict drops the cache entry e of t evicted from the LRU, a newer entry of t
+// is kept.
+func (m *Mapper) evict(t reflect.Type, e *typeMapEntry) {
+	m.cache.CompareAndDelete(t, e)
+}
+
+// typeMapEntry is a cache entry that guarantees a StructMap is built exactly
+// once even if many goroutines request the same type concurrently.
+type typeMapEntry struct {
+	once    sync.Once
+	mapping *StructMap
+}
+
+// FieldMap returns the mapper's mapping of field names to reflect values.
+// Nil pointers are not allocated, fields behind nil pointers, ex. fields
+// promoted from a nil embedded struct pointer, are omitted so that all the
+// values in the map are valid.  Use FieldMapAlloc to get all the fields for
+// setting.  Returns an empty map if v's Kind is not Struct, or v is not
+// Indirectable to a struct kind.
+func (m *Mapper) FieldMap(v reflect.Value) map[string]reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return map[string]reflect.Value{}
+	}
+
+	tm := m.TypeMap(v.Type())
+	r := make(map[string]reflect.Value, len(tm.Names))
+	m.FieldMapInto(v, r)
+	return r
+}
+
+// FieldMapWhere is like FieldMap but it returns only the fields for which
+// pred returns true, ex. the fields that are not zero for a partial update.
+// Fields behind nil pointers, ex. fields of a nil embedded struct pointer, are
+// not passed to pred.
+func (m *Mapper) FieldMapWhere(v reflect.Value, pred func(fi *FieldInfo, val reflect.Value) bool) map[string]reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return map[string]reflect.Value{}
+	}
+
+	tm := m.TypeMap(v.Type())
+	r := map[string]reflect.Value{}
+	for tagName, fi := range tm.Names {
+		if f, ok := tm.readField(v, fi); ok && pred(fi, f) {
+			r[tagName] = f
+		}
+	}
+	return r
+}
+
+// DynamicFieldMap is like FieldMap but it also maps the fields of the
+// structs held by interface fields, ex. for a field tagged `db:"payload"` of
+// type interface{} holding a struct or a pointer to a struct with a field
+// tagged `db:"id"` it includes "payload.id".  Unlike FieldMap it's driven by
+// the values, not only by the type of v, so the result depends on the
+// concrete types held by the interfaces and it costs more, the mappings of
+// the concrete types are cached like the mapping of v's type.  Interfaces
+// held by the concrete types are mapped recursively, a pointer that was
+// already visited is not followed again.  Fields of structs held by value
+// are not settable.  Names of FieldMap win over the dynamic names.
+func (m *Mapper) DynamicFieldMap(v reflect.Value) map[string]reflect.Value {
+	m.mu.RLock()
+	sep := m.opts.separator()
+	m.mu.RUnlock()
+
+	fields := m.FieldMap(v)
+	r := make(map[string]reflect.Value, len(fields))
+	for name, f := range fields {
+		r[name] = f
+	}
+	m.addDynamicFields(r, fields, "", sep, map[visitedPtr]bool{})
+	return r
+}
+
+// visitedPtr identifies a pointer followed by DynamicFieldMap.
+type visitedPtr struct {
+	t reflect.Type
+	p uintptr
+}
+
+// addDynamicFields adds the fields of the structs held by the interface
+// values in fields to r, prefixed with the prefix and the field name.
+func (m *Mapper) addDynamicFields(r, fields map[string]reflect.Value, prefix, sep string, seen map[visitedPtr]bool) {
+	for name, f := range fields {
+		if f.Kind() != reflect.Interface || f.IsNil() {
+			continue
+		}
+		e := f.Elem()
+		if e.Kind() == reflect.Ptr && !e.IsNil() {
+			k := visitedPtr{e.Type(), e.Pointer()}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		e = indirect(e)
+		if e.Kind() != reflect.Struct {
+			continue
+		}
+
+		p := prefix + name + sep
+		dynamic := m.FieldMap(e)
+		for n, df := range dynamic {
+			if _, ok := r[p+n]; !ok {
+				r[p+n] = df
+			}
+		}
+		m.addDynamicFields(r, dynamic, p, sep, seen)
+	}
+}
+
+// FieldMapInto is like FieldMap but it puts the values into dst, so that
+// callers can reuse the maps and avoid allocating a new one for every value.
+// Entries in dst that are not mapped names of v are left intact, names of
+// fields behind nil pointers are deleted.  Panics if v's Kind is not Struct,
+// or v is not Indirectable to a struct kind.
+func (m *Mapper) FieldMapInto(v reflect.Value, dst map[string]reflect.Value) {
+	if isNilPtr(v) {
+		for tagName := range m.TypeMap(v.Type()).Names {
+			delete(dst, tagName)
+		}
+		return
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	for tagName, fi := range tm.Names {
+		if f, ok := tm.readField(v, fi); ok {
+			dst[tagName] = f
+		} else {
+			delete(dst, tagName)
+		}
+	}
+}
+
+// FieldMapAlloc is like FieldMap but nil pointers along the traversals are
+// allocated, so that all the mapped names are included, and the values can be
+// set if v is addressable.  Returns an empty map if v's Kind is not Struct, or
+// v is not Indirectable to a struct kind.
+func (m *Mapper) FieldMapAlloc(v reflect.Value) map[string]reflect.Value {
+	v = allocIndirect(v)
+	if v.Kind() != reflect.Struct {
+		return map[string]reflect.Value{}
+	}
+
+	tm := m.TypeMap(v.Type())
+	r := make(map[string]reflect.Value, len(tm.Names))
+	for tagName, fi := range tm.Names {
+		r[tagName] = tm.field(v, fi)
+	}
+	return r
+}
+
+// Direction is the direction of mapping of fields, see FieldMapDir.
+type Direction int
+
+// Directions of mapping.
+const (
+	// In is binding input to fields, ex. URL parameters to a request model.
+	In Direction = iota
+	// Out is serializing fields to output, ex. a response.
+	Out
+)
+
+// FieldMapDir is like FieldMap but it returns only the fields mapped in the
+// direction dir.  Fields with the "in" option are mapped only In and fields
+// with the "out" option are mapped only Out, ex. `http:"password,in"`, fields
+// with neither option are mapped in both directions.  For In nil pointers are
+// allocated like in FieldMapAlloc so that the fields can be set, nil pointers
+// that are not settable, ex. in a struct that is not addressable, are not
+// allocated and the fields behind them are omitted.  For Out fields behind nil
+// pointers are omitted like in FieldMap.  Returns an empty map if v's Kind is
+// not Struct, or v is not Indirectable to a struct kind.
+func (m *Mapper) FieldMapDir(v reflect.Value, dir Direction) map[string]reflect.Value {
+	if dir == In {
+		v = allocIndirect(v)
+	} else {
+		v = reflect.Indirect(v)
+	}
+	if v.Kind() != reflect.Struct {
+		return map[string]reflect.Value{}
+	}
+
+	skip := "in"
+	if dir == In {
+		skip = "out"
+	}
+
+	tm := m.TypeMap(v.Type())
+	r := make(map[string]reflect.Value, len(tm.Names))
+	for tagName, fi := range tm.Names {
+		if _, ok := fi.Options[skip]; ok {
+			continue
+		}
+		if dir == In {
+			if f, err := FieldByIndexesAlloc(v, fi.Index); err == nil {
+				r[tagName] = f
+				continue
+			}
+		}
+		if f, ok := tm.readField(v, fi); ok {
+			r[tagName] = f
+		}
+	}
+	return r
+}
+
+// PathFieldMap returns the leaf fields of v, that is the fields that are not
+// mapped recursively like structs, keyed by FieldInfo.Path.  Paths are the
+// names joined with "." or the separator set with SetPathSeparator, ex.
+// "asset.title".  Unlike FieldMap it does not include the struct fields
+// themselves, so every value in the map is a leaf field.  Like FieldMap the
+// fields behind nil pointers are omitted, nil pointers are not allocated.
+// Panics if v's Kind is not Struct, or v is not Indirectable to a struct kind.
+func (m *Mapper) PathFieldMap(v reflect.Value) map[string]reflect.Value {
+	if isNilPtr(v) {
+		return map[string]reflect.Value{}
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	r := make(map[string]reflect.Value, len(tm.Paths))
+	for path, fi := range tm.Paths {
+		if fi.Name == "" || len(fi.Children) != 0 {
+			continue
+		}
+		if f, ok := tm.readField(v, fi); ok {
+			r[path] = f
+		}
+	}
+	return r
+}
+
+// OrderedFieldMap is like FieldMap but it returns the names and the values in
+// struct declaration order ordered as in StructMap.Index.  The slices are
+// index-aligned, the value of names[i] is values[i].  Fields behind nil
+// pointers are omitted from both, nil pointers are not allocated.  Panics if
+// v's Kind is not Struct, or v is not Indirectable to a struct kind.
+func (m *Mapper) OrderedFieldMap(v reflect.Value) ([]string, []reflect.Value) {
+	if isNilPtr(v) {
+		return []string{}, []reflect.Value{}
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	names := make([]string, 0, len(tm.Names))
+	values := make([]reflect.Value, 0, len(tm.Names))
+	for _, fi := range tm.Index {
+		if tm.Names[fi.Path] != fi {
+			continue
+		}
+		f, ok := tm.readField(v, fi)
+		if !ok {
+			continue
+		}
+		names = append(names, fi.Path)
+		values = append(values, f)
+	}
+	return names, values
+}
+
+// WalkValues calls fn for every leaf field of v, that is a field that is not
+// mapped recursively like structs, with its value in struct declaration order,
+// see StructMap.Walk.  Only the fields that are mapped names are visited,
+// fields behind nil pointers are skipped, nil pointers are not allocated.  It
+// stops when fn returns false.  Panics if v's Kind is not Struct, or v is not
+// Indirectable to a struct kind.
+func (m *Mapper) WalkValues(v reflect.Value, fn func(fi *FieldInfo, v reflect.Value) bool) {
+	if isNilPtr(v) {
+		return
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	walkValues(tm, tm.Tree, v, fn)
+}
+
+func walkValues(tm *StructMap, tree *FieldInfo, v reflect.Value, fn func(fi *FieldInfo, v reflect.Value) bool) bool {
+	for _, fi := range tree.Children {
+		if fi == nil {
+			continue
+		}
+		f := v.Field(fi.Index[len(fi.Index)-1])
+		if len(fi.Children) == 0 {
+			if tm.Names[fi.Path] == fi && !fn(fi, f) {
+				return false
+			}
+			continue
+		}
+		for f.Kind() == reflect.Ptr && !f.IsNil() {
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Ptr {
+			continue
+		}
+		if !walkValues(tm, fi, f, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// LeafField is a leaf field with its value, see LeafFields.
+type LeafField struct {
+	Info *FieldInfo
+	// Value is the value of the field, it's the zero value of the field's
+	// type if the field is behind a nil pointer.
+	Value reflect.Value
+	// root is the struct the field is in.
+	root reflect.Value
+}
+
+// Alloc allocates the nil pointers on the way to the field, ex. embedded
+// struct pointers, and returns the settable field, ex. when a decoder has a
+// value for it.  The field itself is not allocated, ex. a nil *int field
+// stays nil.  Returns zero Value if the root passed to LeafFields is not
+// addressable or a nil pointer on the way can not be set, ex. an unexported
+// embedded struct pointer.
+func (lf LeafField) Alloc() reflect.Value {
+	if !lf.root.CanAddr() {
+		return reflect.Value{}
+	}
+	f, _ := leafValue(lf.root, lf.Info.Index)
+	return f
+}
+
+// LeafFields returns the leaf fields of root with their values in struct
+// declaration order, the fields named by LeafNames, ex. for a generic encoder
+// or decoder.  Nil pointers on the way to the fields are not allocated, the
+// values of the fields behind them are the zero values of the fields' types,
+// use LeafField.Alloc to allocate them when setting a field.  If root is
+// addressable, ex. a pointer to a struct, the values of the fields that are
+// not behind nil pointers are settable, except for unexported fields, and a
+// nil root is allocated.  Otherwise the values can only be read.  Panics if
+// root's Kind is not Struct, or root is not Indirectable to a struct kind.
+func (m *Mapper) LeafFields(root reflect.Value) []LeafField {
+	v := root
+	if isNilPtr(v) && !v.CanSet() {
+		v = reflect.Zero(v.Type().Elem())
+	}
+	v = allocIndirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	fields := make([]LeafField, 0, len(tm.Names))
+	tm.Walk(func(fi *FieldInfo) bool {
+		if tm.Names[fi.Path] != fi || len(fi.Children) != 0 {
+			return true
+		}
+		f, ok := tm.readField(v, fi)
+		if !ok {
+			f = reflect.Zero(fi.Field.Type)
+		}
+		fields = append(fields, LeafField{Info: fi, Value: f, root: v})
+		return true
+	})
+	return fields
+}
+
+// leafValue is like FieldByIndexesAlloc but the field itself is not
+// allocated, only the nil pointers on the way to it.  It returns false if a
+// nil pointer is not settable.
+func leafValue(v reflect.Value, indexes []int) (reflect.Value, bool) {
+	for i, idx := range indexes {
+		if i != 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					if !v.CanSet() {
+						return reflect.Value{}, false
+					}
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// FieldMapSlice returns the FieldMapAlloc of every element of the slice v,
+// nil pointer elements are allocated if v is addressable.  Panics if v's Kind is
+// not Slice, or v is not Indirectable to a slice kind.
+func (m *Mapper) FieldMapSlice(v reflect.Value) []map[string]reflect.Value {
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Slice)
+
+	r := make([]map[string]reflect.Value, v.Len())
+	for i := range r {
+		r[i] = m.FieldMapAlloc(sliceElem(v.Index(i)))
+	}
+	return r
+}
+
+// FieldMapIndex returns the FieldMapAlloc of the i-th element of the slice v, if
+// i is out of range the slice is grown with zero values to have i+1 elements,
+// this is useful for binding form arrays like "items[0].name".  A nil pointer
+// element is allocated.  Panics if v's Kind is not Slice, or v is not
+// Indirectable to a slice kind, or the slice needs to grow and it's not
+// settable.
+func (m *Mapper) FieldMapIndex(v reflect.Value, i int) map[string]reflect.Value {
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Slice)
+
+	if n := i + 1 - v.Len(); n > 0 {
+		v.Set(reflect.AppendSlice(v, reflect.MakeSlice(v.Type(), n, n)))
+	}
+	return m.FieldMapAlloc(sliceElem(v.Index(i)))
+}
+
+// sliceElem allocates e if it's a settable nil pointer.
+func sliceElem(e reflect.Value) reflect.Value {
+	if e.Kind() == reflect.Ptr && e.IsNil() && e.CanSet() {
+		e.Set(reflect.New(e.Type().Elem()))
+	}
+	return e
+}
+
+// ToMap returns the mapper's mapping of field names to the field values.
+// Fields behind nil pointers and unexported fields are omitted.  Panics if
+// v's Kind is not Struct, or v is not Indirectable to a struct kind.
+func (m *Mapper) ToMap(v reflect.Value) map[string]interface{} {
+	if isNilPtr(v) {
+		return map[string]interface{}{}
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	r := map[string]interface{}{}
+	tm := m.TypeMap(v.Type())
+	for tagName, fi := range tm.Names {
+		if f, ok := fieldByIndexesReadOnly(v, fi.Index); ok && f.CanInterface() {
+			r[tagName] = f.Interface()
+		}
+	}
+	return r
+}
+
+// FieldMapPtr is like FieldMap but guarantees that all the returned values are
+// addressable, nil pointers along the traversals are allocated.  Panics if v's
+// Kind is not Struct, or v is not Indirectable to a struct kind, or v is not
+// addressable.
+func (m *Mapper) FieldMapPtr(v reflect.Value) map[string]reflect.Value {
+	r, err := m.FieldMapPtrE(v)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// FieldMapPtrE is like FieldMapPtr but returns an error if v is not
+// addressable or v's Kind is not Struct, or v is not Indirectable to a struct
+// kind.
+func (m *Mapper) FieldMapPtrE(v reflect.Value) (map[string]reflect.Value, error) {
+	v = reflect.Indirect(v)
+	if err := mustBeStruct(v); err != nil {
+		return nil, err
+	}
+
+	if !v.CanAddr() {
+		return nil, fmt.Errorf("%s is not addressable", v.Type())
+	}
+
+	r := map[string]reflect.Value{}
+	tm := m.TypeMap(v.Type())
+	for tagName, fi := range tm.Names {
+		r[tagName] = FieldByIndexes(v, fi.Index)
+	}
+	return r, nil
+}
+
+// FieldByName returns a field by its mapped name as a reflect.Value.  Nil
+// pointers along the traversal, ex. nil embedded struct pointers, are
+// allocated if they are settable, a nil v is not.  Returns zero Value if v's
+// Kind is not Struct or v is not Indirectable to a struct Kind.  Returns zero
+// Value if the name is not found.
+func (m *Mapper) FieldByName(v reflect.Value, name string) reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return reflect.Value{}
+	}
+	return tm.field(v, fi)
+}
+
+// FieldByNameAlloc is like FieldByName but it's meant for setting fields, a
+// nil v is allocated if it's settable, and v must be addressable so that the
+// returned Value is settable, except for unexported fields.  Returns zero
+// Value if the name is not found.  Panics if v's Kind is not Struct, or v is
+// not Indirectable to a struct Kind, or v is not addressable.
+func (m *Mapper) FieldByNameAlloc(v reflect.Value, name string) reflect.Value {
+	v = allocIndirect(v)
+	mustBe(v, reflect.Struct)
+	if !v.CanAddr() {
+		panic(fmt.Errorf("%s is not addressable", v.Type()))
+	}
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return reflect.Value{}
+	}
+	return tm.field(v, fi)
+}
+
+// FieldByNameLazy is like FieldByNameAlloc but nil pointers along the
+// traversal are allocated only when the returned function is called, ex. when
+// the caller has parsed a parameter and commits to setting the field, so that
+// absent or invalid parameters do not leave allocated empty embedded structs.
+// The returned Value is the current value of the field, it's zero Value if the
+// field is behind a nil pointer.  The function allocates the nil pointers and
+// returns the settable field.  Returns zero Value and nil function if the
+// name is not found.  Panics if root's Kind is not Struct, or root is not
+// Indirectable to a struct Kind, or root is not addressable.
+func (m *Mapper) FieldByNameLazy(root reflect.Value, name string) (reflect.Value, func() reflect.Value) {
+	v := allocIndirect(root)
+	mustBe(v, reflect.Struct)
+	if !v.CanAddr() {
+		panic(fmt.Errorf("%s is not addressable", v.Type()))
+	}
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return reflect.Value{}, nil
+	}
+	f, _ := tm.readField(v, fi)
+	return f, func() reflect.Value {
+		return tm.field(v, fi)
+	}
+}
+
+// Accessor returns a function returning the field with the mapped name of
+// structs of type t like FieldByName, but it does not allocate nil pointers,
+// it returns zero Value if root is a nil pointer or the field is behind a nil
+// pointer.  The traversal is resolved once into direct Field calls with nil
+// checks only for the pointer fields on the way, so it's faster than
+// FieldByName on hot read paths.  The accessors are cached with the mapping
+// of t.  Returns nil if the name is not found.  The returned function panics
+// if root's Kind is not Struct or root is not Indirectable to a struct Kind.
+func (m *Mapper) Accessor(t reflect.Type, name string) func(root reflect.Value) reflect.Value {
+	tm := m.TypeMap(t)
+	if a, ok := tm.accessors.Load(name); ok {
+		return a.(func(reflect.Value) reflect.Value)
+	}
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return nil
+	}
+	a, _ := tm.accessors.LoadOrStore(name, newAccessor(Deref(t), fi))
+	return a.(func(reflect.Value) reflect.Value)
+}
+
+// newAccessor returns a function returning the field described by fi of
+// structs of type t, see Accessor.  The traversal is composed of a step for
+// every index, the steps into pointer fields check for nil pointers.
+func newAccessor(t reflect.Type, fi *FieldInfo) func(root reflect.Value) reflect.Value {
+	index := fi.Index
+	// ptr tells which fields on the traversal are pointers
+	ptr := make([]bool, len(index))
+	for k, typ := 0, t; k < len(index); k++ {
+		ft := typ.Field(index[k]).Type
+		ptr[k] = ft.Kind() == reflect.Ptr
+		typ = Deref(ft)
+	}
+
+	last := index[len(index)-1]
+	get := func(v reflect.Value) reflect.Value {
+		return v.Field(last)
+	}
+	for k := len(index) - 2; k >= 0; k-- {
+		i, next := index[k], get
+		if ptr[k] {
+			get = func(v reflect.Value) reflect.Value {
+				v = v.Field(i)
+				for v.Kind() == reflect.Ptr {
+					if v.IsNil() {
+						return reflect.Value{}
+					}
+					v = v.Elem()
+				}
+				return next(v)
+			}
+		} else {
+			get = func(v reflect.Value) reflect.Value {
+				return next(v.Field(i))
+			}
+		}
+	}
+	return func(root reflect.Value) reflect.Value {
+		if isNilPtr(root) {
+			return reflect.Value{}
+		}
+		root = reflect.Indirect(root)
+		mustBe(root, reflect.Struct)
+		return get(root)
+	}
+}
+
+// FieldByNameE is like FieldByName but it returns a *FieldNotFoundError if
+// the name is not found, and an error if v's Kind is not Struct or v is not
+// Indirectable to a struct Kind.
+func (m *Mapper) FieldByNameE(v reflect.Value, name string) (reflect.Value, error) {
+	v = reflect.Indirect(v)
+	if err := mustBeStruct(v); err != nil {
+		return reflect.Value{}, err
+	}
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.lookup(name)
+	if !ok {
+		return reflect.Value{}, &FieldNotFoundError{Name: name, Type: v.Type()}
+	}
+	return FieldByIndexes(v, fi.Index), nil
+}
+
+// FieldByNameNorm is like FieldByName but name is normalized with norm before
+// the lookup, ex. to match parameters using dashes with fields named with
+// underscores.  The mapping is not changed, norm must turn name into the form
+// of the names in Names i.e. the form produced by the tags and the NameFunc
+// of the mapper, ex. with the mapper created with NewMapperFunc("db",
+// strings.ToLower) norm should lowercase the names too.  Returns zero Value
+// if the name is not found.  Panics if v's Kind is not Struct, or v is not
+// Indirectable to a struct Kind.
+func (m *Mapper) FieldByNameNorm(v reflect.Value, name string, norm func(string) string) reflect.Value {
+	if isNilPtr(v) {
+		return reflect.Value{}
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.lookup(norm(name))
+	if !ok {
+		return reflect.Value{}
+	}
+	return tm.field(v, fi)
+}
+
+// FieldNotFoundError is returned when a mapped name is not found in a struct.
+type FieldNotFoundError struct {
+	Name string
+	Type reflect.Type
+}
+
+func (e *FieldNotFoundError) Error() string {
+	return fmt.Sprintf("%s: no such field in %s", e.Name, e.Type)
+}
+
+// FieldByPath returns a field by its full dotted path (as found in
+// StructMap.Paths) as a reflect.Value.  Nil pointers along the traversal are
+// allocated, so the returned Value is settable if v is addressable.  Panics if
+// v's Kind is not Struct or v is not Indirectable to a struct Kind.  Returns
+// zero Value if the path is not found.
+func (m *Mapper) FieldByPath(v reflect.Value, path string) reflect.Value {
+	if isNilPtr(v) {
+		return reflect.Value{}
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	fi, ok := tm.Paths[path]
+	if !ok {
+		return reflect.Value{}
+	}
+	return FieldByIndexes(v, fi.Index)
+}
+
+// SubStructByPath is like FieldByPath but it returns only struct fields with
+// children in the mapping, embedded or not, ex. "RequestContext" for an
+// embedded RequestContext struct without a tag, to operate on the whole
+// subtree.  Nil pointers along the traversal and the pointer to the struct
+// are allocated, the returned Value is the struct, not a pointer to it, and it
+// is settable.  Returns false if the path is not found or the field has no
+// children.  Panics if root's Kind is not Struct, or root is not Indirectable
+// to a struct Kind, or root is not addressable.
+func (m *Mapper) SubStructByPath(root reflect.Value, path string) (reflect.Value, bool) {
+	root = allocIndirect(root)
+	mustBe(root, reflect.Struct)
+	if !root.CanAddr() {
+		panic(fmt.Errorf("%s is not addressable", root.Type()))
+	}
+
+	fi, ok := m.TypeMap(root.Type()).Paths[path]
+	if !ok || len(fi.Children) == 0 {
+		return reflect.Value{}, false
+	}
+	return indirect(FieldByIndexes(root, fi.Index)), true
+}
+
+// FieldsByName returns a slice of values corresponding to the slice of names
+// for the value.  Panics if v's Kind is not Struct or v is not Indirectable
+// to a struct Kind.  Returns zero Value for each name not found.
+func (m *Mapper) FieldsByName(v reflect.Value, names []string) []reflect.Value {
+	if isNilPtr(v) {
+		return make([]reflect.Value, len(names))
+	}
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	tm := m.TypeMap(v.Type())
+	vals := make([]reflect.Value, 0, len(names))
+	for _, name := range names {
+		fi, ok := tm.lookup(name)
+		if !ok {
+			vals = append(vals, *new(reflect.Value))
+		} else {
+			vals = append(vals, FieldByIndexes(v, fi.Index))
+		}
+	}
+	return vals
+}
+
+// TraversalsByName returns a slice of int slices which represent the struct
+// traversals for each mapped name.  Returns empty int slice for each name not
+// found, or for every name if t is not a struct or Indirectable to a struct.
+func (m *Mapper) TraversalsByName(t reflect.Type, names []string) [][]int {
+	return m.TraversalsByNameAppend(t, names, make([][]int, 0, len(names)))
+}
+
+// TraversalsByNameAppend is like TraversalsByName but it appends the
+// traversals to dst and returns the extended slice, callers can reuse dst
+// with dst[:0] to avoid allocating for every call ex. for every row.  The
+// traversals are shared with the cached mapping and must not be modified.
+func (m *Mapper) TraversalsByNameAppend(t reflect.Type, names []string, dst [][]int) [][]int {
+	if t == nil || Deref(t).Kind() != reflect.Struct {
+		for range names {
+			dst = append(dst, []int{})
+		}
+		return dst
+	}
+	return m.TypeMap(Deref(t)).appendTraversals(names, dst)
+}
+
+// Traversal is the traversal of a mapped name, it's TraversalsByName with the
+// name and the field for error reporting and logging.
+type Traversal struct {
+	// Name is the requested name.
+	Name string
+	// Index is the traversal of the field, the same as in TraversalsByName.
+	Index []int
+	// Field is the field, it's nil if the name is not found.
+	Field *FieldInfo
+}
+
+// Traversals is like TraversalsByName but it returns a Traversal for each
+// name, the traversals of names that are not found have empty Index and nil
+// Field.  TraversalsByName is faster if only the indexes are needed.
+func (m *Mapper) Traversals(t reflect.Type, names []string) []Traversal {
+	ts := make([]Traversal, len(names))
+	var tm *StructMap
+	if t != nil && Deref(t).Kind() == reflect.Struct {
+		tm = m.TypeMap(t)
+	}
+	for i, name := range names {
+		ts[i] = Traversal{Name: name, Index: []int{}}
+		if tm == nil {
+			continue
+		}
+		if fi, ok := tm.lookup(name); ok {
+			ts[i].Index = fi.Index
+			ts[i].Field = fi
+		}
+	}
+	return ts
+}
+
+// TraversalsByNameDepth is like TraversalsByName but it matches only the fields
+// that are at most maxDepth levels deep, ie. have traversals no longer than
+// maxDepth.  With maxDepth 1 only the fields declared directly in t are
+// matched, names promoted from embedded structs are not.  If maxDepth is 0
+// the depth is not limited.
+func (m *Mapper) TraversalsByNameDepth(t reflect.Type, names []string, maxDepth int) [][]int {
+	r := make([][]int, 0, len(names))
+	if t == nil || Deref(t).Kind() != reflect.Struct {
+		for range names {
+			r = append(r, []int{})
+		}
+		return r
+	}
+	tm := m.TypeMap(Deref(t))
+
+	for _, name := range names {
+		if fi, ok := tm.lookup(name); ok && (maxDepth <= 0 || fi.Depth <= maxDepth) {
+			r = append(r, fi.Index)
+		} else {
+			r = append(r, []int{})
+		}
 	}
+	return r
 }
 
-// NewMapperFunc returns a new mapper which optionally obeys a field tag and
-// a struct field name mapper func given by f.  Tags will take precedence, but
-// for any other field, the mapped name will be f(field.Name)
-func NewMapperFunc(tagName string, f func(string) string) *Mapper {
-	return &Mapper{
-		tagName: tagName,
-		mapFunc: f,
+// CachedTraversals is like TraversalsByName but the result is computed once
+// for every type and list of names and cached.  The returned slices are shared
+// between calls and must not be modified.
+func (m *Mapper) CachedTraversals(t reflect.Type, names []string) [][]int {
+	if t == nil || Deref(t).Kind() != reflect.Struct {
+		return m.TraversalsByName(t, names)
 	}
-}
 
-// TypeMap returns a mapping of field strings to int slices representing
-// the traversal down the struct to reach the field.
-func (m *Mapper) TypeMap(t reflect.Type) *StructMap {
-	if mapping, ok := m.cache.Load(t); ok {
-		return mapping.(*StructMap)
+	// the traversals are cached with the mapping they are computed from, so
+	// that they are dropped with it when a setting is changed
+	tm := m.TypeMap(Deref(t))
+	key := strings.Join(names, "\x00")
+	if v, ok := tm.traversals.Load(key); ok {
+		return v.([][]int)
 	}
+	v, _ := tm.traversals.LoadOrStore(key, tm.appendTraversals(names, make([][]int, 0, len(names))))
+	return v.([][]int)
+}
 
-	mapping, _ := m.cache.LoadOrStore(t, getMapping(t, m.tagName, m.mapFunc, m.tagMapFunc))
+// FieldPointers returns pointers to the fields of root given by the
+// traversals, ex. traversals returned by TraversalsByName for the columns of
+// sql.Rows, so that they can be passed to rows.Scan.  Nil pointers along the
+// traversals are allocated.  It returns an error if root is not addressable,
+// root's Kind is not Struct or root is not Indirectable to a struct Kind, or
+// any of the traversals is empty i.e. the name is not found.
+func (m *Mapper) FieldPointers(root reflect.Value, traversals [][]int) ([]interface{}, error) {
+	v := reflect.Indirect(root)
+	if err := mustBeStruct(v); err != nil {
+		return nil, err
+	}
+	if !v.CanAddr() {
+		return nil, fmt.Errorf("%s is not addressable", v.Type())
+	}
 
-	return mapping.(*StructMap)
+	ptrs := make([]interface{}, len(traversals))
+	for i, idx := range traversals {
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("empty traversal %d for %s", i, v.Type())
+		}
+		f, err := FieldByIndexesAlloc(v, idx)
+		if err != nil {
+			return nil, err
+		}
+		ptrs[i] = f.Addr().Interface()
+	}
+	return ptrs, nil
 }
 
-// FieldMap returns the mapper's mapping of field names to reflect values.  Panics
-// if v's Kind is not Struct, or v is not Indirectable to a struct kind.
-func (m *Mapper) FieldMap(v reflect.Value) map[string]reflect.Value {
-	v = reflect.Indirect(v)
-	mustBe(v, reflect.Struct)
+// TraversalGroup is a group of traversals of fields of the same struct, see
+// GroupTraversals.
+type TraversalGroup struct {
+	// Prefix is the traversal of the struct shared by the traversals, it's
+	// empty for the fields of the root struct.
+	Prefix []int
+	// Positions are the positions of the traversals in the grouped slice,
+	// in increasing order.
+	Positions []int
+}
 
-	r := map[string]reflect.Value{}
-	tm := m.TypeMap(v.Type())
-	for tagName, fi := range tm.Names {
-		r[tagName] = FieldByIndexes(v, fi.Index)
+// GroupTraversals groups the traversals, ex. returned by TraversalsByName,
+// by the traversal of the struct holding the field, i.e. all the indexes but
+// the last one, so that the struct, ex. behind an embedded struct pointer, is
+// resolved and allocated once for all its fields instead of once per field.
+// The field at position p of a group g is the field with index
+// traversals[p][len(g.Prefix)] of the struct reached by g.Prefix.  The groups
+// are in the order of the first traversal of each group, empty traversals
+// are skipped.
+func GroupTraversals(traversals [][]int) []TraversalGroup {
+	var groups []TraversalGroup
+	byPrefix := make(map[string]int)
+	var key []byte
+	for i, idx := range traversals {
+		if len(idx) == 0 {
+			continue
+		}
+		prefix := idx[:len(idx)-1]
+		key = key[:0]
+		for _, j := range prefix {
+			key = strconv.AppendInt(key, int64(j), 10)
+			key = append(key, ',')
+		}
+		// the lookup does not allocate the string, only the insert does
+		g, ok := byPrefix[string(key)]
+		if !ok {
+			g = len(groups)
+			byPrefix[string(key)] = g
+			groups = append(groups, TraversalGroup{Prefix: prefix[:len(prefix):len(prefix)]})
+		}
+		groups[g].Positions = append(groups[g].Positions, i)
 	}
-	return r
+	return groups
 }
 
-// FieldByName returns a field by its mapped name as a reflect.Value.
-// Panics if v's Kind is not Struct or v is not Indirectable to a struct Kind.
-// Returns zero Value if the name is not found.
-func (m *Mapper) FieldByName(v reflect.Value, name string) reflect.Value {
-	v = reflect.Indirect(v)
-	mustBe(v, reflect.Struct)
+// ValueByTraversal returns the field of root given by the traversal index,
+// ex. a traversal returned by TraversalsByName.  If alloc is set nil pointers
+// along the traversal are allocated like in FieldByIndexesAlloc, it returns
+// an error if a nil pointer can not be allocated because root is not
+// addressable.  Otherwise nil pointers are not allocated and it returns an
+// error if the field is behind a nil pointer.  It returns an error if root's
+// Kind is not Struct or root is not Indirectable to a struct Kind, or the
+// traversal is not a traversal of a mapped field.
+func (m *Mapper) ValueByTraversal(root reflect.Value, index []int, alloc bool) (reflect.Value, error) {
+	v := reflect.Indirect(root)
+	if err := mustBeStruct(v); err != nil {
+		return reflect.Value{}, err
+	}
+	if _, ok := m.TypeMap(v.Type()).GetByTraversalOK(index); !ok {
+		return reflect.Value{}, fmt.Errorf("no field with traversal %v in %s", index, v.Type())
+	}
 
-	tm := m.TypeMap(v.Type())
-	fi, ok := tm.Names[name]
+	if alloc {
+		return FieldByIndexesAlloc(v, index)
+	}
+	f, ok := fieldByIndexesReadOnly(v, index)
 	if !ok {
-		return v
+		return reflect.Value{}, fmt.Errorf("field with traversal %v in %s is behind a nil pointer", index, v.Type())
 	}
-	return FieldByIndexes(v, fi.Index)
+	return f, nil
 }
 
-// FieldsByName returns a slice of values corresponding to the slice of names
-// for the value.  Panics if v's Kind is not Struct or v is not Indirectable
-// to a struct Kind.  Returns zero Value for each name not found.
-func (m *Mapper) FieldsByName(v reflect.Value, names []string) []reflect.Value {
+// ValuesByNames is like FieldsByName but it resolves the names using
+// CachedTraversals, which makes it cheap to call repeatedly with the same
+// names ex. for every row.  The values are returned in the order of names,
+// with zero Value for each name not found.  Panics if v's Kind is not Struct
+// or v is not Indirectable to a struct Kind.
+func (m *Mapper) ValuesByNames(v reflect.Value, names []string) []reflect.Value {
+	if isNilPtr(v) {
+		return make([]reflect.Value, len(names))
+	}
 	v = reflect.Indirect(v)
 	mustBe(v, reflect.Struct)
 
-	tm := m.TypeMap(v.Type())
-	vals := make([]reflect.Value, 0, len(names))
-	for _, name := range names {
-		fi, ok := tm.Names[name]
-		if !ok {
-			vals = append(vals, *new(reflect.Value))
-		} else {
-			vals = append(vals, FieldByIndexes(v, fi.Index))
+	traversals := m.CachedTraversals(v.Type(), names)
+	vals := make([]reflect.Value, len(traversals))
+	for i, idx := range traversals {
+		if len(idx) != 0 {
+			vals[i] = FieldByIndexes(v, idx)
 		}
 	}
 	return vals
 }
 
-// TraversalsByName returns a slice of int slices which represent the struct
-// traversals for each mapped name.  Panics if t is not a struct or Indirectable
-// to a struct.  Returns empty int slice for each name not found.
-func (m *Mapper) TraversalsByName(t reflect.Type, names []string) [][]int {
+// TraversalsByNameStrict is like TraversalsByName but it also returns the names
+// that are not found and an error if there are any.
+func (m *Mapper) TraversalsByNameStrict(t reflect.Type, names []string) ([][]int, []string, error) {
+	var missing []string
 	r := make([][]int, 0, len(names))
-	m.TraversalsByNameFunc(t, names, func(_ int, i []int) error { // nolint
-		if i == nil {
+	m.TraversalsByNameFunc(t, names, func(i int, idx []int) error { // nolint
+		if idx == nil {
+			missing = append(missing, names[i])
 			r = append(r, []int{})
 		} else {
-			r = append(r, i)
+			r = append(r, idx)
 		}
 
 		return nil
 	})
-	return r
+	if len(missing) > 0 {
+		return r, missing, fmt.Errorf("missing fields for names %s in %s", strings.Join(missing, ", "), Deref(t))
+	}
+	return r, nil, nil
+}
+
+// HeaderTraversals returns the struct traversals for the columns of a header
+// row ex. in CSV, in the order of the columns.  It returns an error naming the
+// first column that has no matching field.  Panics if t is not a struct or
+// Indirectable to a struct.
+func (m *Mapper) HeaderTraversals(t reflect.Type, header []string) ([][]int, error) {
+	r := make([][]int, 0, len(header))
+	err := m.TraversalsByNameFunc(t, header, func(i int, idx []int) error {
+		if idx == nil {
+			return fmt.Errorf("no field for column %d %q in %s", i, header[i], Deref(t))
+		}
+		r = append(r, idx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // TraversalsByNameFunc traverses the mapped names and calls fn with the index of
@@ -187,7 +2214,7 @@ func (m *Mapper) TraversalsByNameFunc(t reflect.Type, names []string, fn func(in
 	mustBe(t, reflect.Struct)
 	tm := m.TypeMap(t)
 	for i, name := range names {
-		fi, ok := tm.Names[name]
+		fi, ok := tm.lookup(name)
 		if !ok {
 			if err := fn(i, nil); err != nil {
 				return err
@@ -205,11 +2232,13 @@ func (m *Mapper) TraversalsByNameFunc(t reflect.Type, names []string, fn func(in
 // for the given value.
 func FieldByIndexes(v reflect.Value, indexes []int) reflect.Value {
 	for _, i := range indexes {
-		v = reflect.Indirect(v).Field(i)
-		// if this is a pointer and it's nil, allocate a new value and set it
-		if v.Kind() == reflect.Ptr && v.IsNil() {
-			alloc := reflect.New(Deref(v.Type()))
-			v.Set(alloc)
+		v = indirect(v).Field(i)
+		// if this is a pointer and it's nil, allocate a new value and set it,
+		// pointers to pointers are allocated at every level
+		for p := v; p.Kind() == reflect.Ptr; p = p.Elem() {
+			if p.IsNil() {
+				p.Set(reflect.New(p.Type().Elem()))
+			}
 		}
 		if v.Kind() == reflect.Map && v.IsNil() {
 			v.Set(reflect.MakeMap(v.Type()))
@@ -218,24 +2247,96 @@ func FieldByIndexes(v reflect.Value, indexes []int) reflect.Value {
 	return v
 }
 
+// FieldByIndexesAlloc is like FieldByIndexes, it allocates nil pointers and
+// maps along the traversal, but it returns an error instead of panicking if
+// a nil value can not be allocated because it is not settable.
+func FieldByIndexesAlloc(v reflect.Value, indexes []int) (reflect.Value, error) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return reflect.Value{}, fmt.Errorf("cannot allocate %s: nil pointer is not settable", v.Type())
+	}
+	for _, i := range indexes {
+		v = indirect(v).Field(i)
+		for p := v; p.Kind() == reflect.Ptr; p = p.Elem() {
+			if p.IsNil() {
+				if !p.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot allocate %s: value is not settable", p.Type())
+				}
+				p.Set(reflect.New(p.Type().Elem()))
+			}
+		}
+		if v.Kind() == reflect.Map && v.IsNil() {
+			if !v.CanSet() {
+				return reflect.Value{}, fmt.Errorf("cannot allocate %s: value is not settable", v.Type())
+			}
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	}
+	return v, nil
+}
+
 // FieldByIndexesReadOnly returns a value for a particular struct traversal,
 // but is not concerned with allocating nil pointers because the value is
 // going to be used for reading and not setting.
 func FieldByIndexesReadOnly(v reflect.Value, indexes []int) reflect.Value {
 	for _, i := range indexes {
-		v = reflect.Indirect(v).Field(i)
+		v = indirect(v).Field(i)
 	}
 	return v
 }
 
-// Deref is Indirect for reflect.Types
+// fieldByIndexesReadOnly is like FieldByIndexesReadOnly but instead of
+// panicking it returns false if the traversal goes through a nil pointer.
+func fieldByIndexesReadOnly(v reflect.Value, indexes []int) (reflect.Value, bool) {
+	for _, i := range indexes {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// Deref is Indirect for reflect.Types, it follows all pointer levels.
 func Deref(t reflect.Type) reflect.Type {
-	if t.Kind() == reflect.Ptr {
+	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 	return t
 }
 
+// Mappable reports whether t is a struct or a pointer to a struct, at any
+// pointer level, ex. to check a type before passing it to a Mapper, for other
+// types the mappings are empty.
+func Mappable(t reflect.Type) bool {
+	return t != nil && Deref(t).Kind() == reflect.Struct
+}
+
+// isNilPtr reports whether v is a nil pointer, such a root is an empty
+// struct for the methods that only read fields, see Mapper.
+func isNilPtr(v reflect.Value) bool {
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// allocIndirect is like reflect.Indirect but a nil pointer is allocated if
+// it's settable.
+func allocIndirect(v reflect.Value) reflect.Value {
+	if isNilPtr(v) && v.CanSet() {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	return reflect.Indirect(v)
+}
+
+// indirect is reflect.Indirect following all pointer levels.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return v
+}
+
 // -- helpers & utilities --
 
 type kinder interface {
@@ -250,6 +2351,14 @@ func mustBe(v kinder, expected reflect.Kind) { // nolint: unparam
 	}
 }
 
+// mustBeStruct returns an error if v's Kind is not Struct.
+func mustBeStruct(v reflect.Value) error {
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("expected struct, got %s", v.Kind())
+	}
+	return nil
+}
+
 // methodName returns the caller of the function calling methodName
 func methodName() string {
 	pc, _, _, _ := runtime.Caller(2)
@@ -261,9 +2370,24 @@ func methodName() string {
 }
 
 type typeQueue struct {
-	t  reflect.Type
-	fi *FieldInfo
-	pp string // Parent path
+	t   reflect.Type
+	fi  *FieldInfo
+	pp  string // Parent path
+	sep string // Parent path separator, empty for the default separator
+}
+
+// isRecursive reports whether ft is the root type rt or the type of any of
+// the ancestors of the fields of tq.
+func isRecursive(rt reflect.Type, tq typeQueue, ft reflect.Type) bool {
+	if ft == rt {
+		return true
+	}
+	for p := tq.fi; p.Parent != nil; p = p.Parent {
+		if Deref(p.Field.Type) == ft {
+			return true
+		}
+	}
+	return false
 }
 
 // A copying append that creates a new slice each time.
@@ -276,11 +2400,36 @@ func apnd(is []int, i int) []int {
 
 type mapf func(string) string
 
+// tagNames returns the tag names for a single tagName, an empty tagName
+// means no tags.
+func tagNames(tagName string) []string {
+	if tagName == "" {
+		return nil
+	}
+	return []string{tagName}
+}
+
+// lookupTag returns the value of the first of tagNames that is set in the tag,
+// a tag with a non-empty value takes precedence over an empty one.
+func lookupTag(tag reflect.StructTag, tagNames []string) (value string, ok bool) {
+	for _, name := range tagNames {
+		v, found := tag.Lookup(name)
+		if !found {
+			continue
+		}
+		if v != "" {
+			return v, true
+		}
+		ok = true
+	}
+	return "", ok
+}
+
 // parseName parses the tag and the target name for the given field using
-// the tagName (eg 'json' for `json:"foo"` tags), mapFunc for mapping the
+// the tagNames (eg 'json' for `json:"foo"` tags), mapFunc for mapping the
 // field's name to a target name, and tagMapFunc for mapping the tag to
 // a target name.
-func parseName(field reflect.StructField, tagName string, mapFunc, tagMapFunc mapf) (tag, fieldName string) {
+func parseName(field reflect.StructField, tagNames []string, mapFunc, tagMapFunc mapf) (tag, fieldName string) {
 	// first, set the fieldName to the field's name
 	fieldName = field.Name
 	// if a mapFunc is set, use that to override the fieldName
@@ -288,24 +2437,12 @@ func parseName(field reflect.StructField, tagName string, mapFunc, tagMapFunc ma
 		fieldName = mapFunc(fieldName)
 	}
 
-	// if there's no tag to look for, return the field name
-	if tagName == "" {
-		return "", fieldName
-	}
-
-	// if this tag is not set using the normal convention in the tag,
-	// then return the fieldname..  this check is done because according
-	// to the reflect documentation:
-	//    If the tag does not have the conventional format,
-	//    the value returned by Get is unspecified.
-	// which doesn't sound great.
-	if !strings.Contains(string(field.Tag), tagName+":") {
+	// if none of the tags is set, return the field name
+	tag, ok := lookupTag(field.Tag, tagNames)
+	if !ok {
 		return "", fieldName
 	}
 
-	// at this point we're fairly sure that we have a tag, so lets pull it out
-	tag = field.Tag.Get(tagName)
-
 	// if we have a mapper function, call it on the whole tag
 	// XXX: this is a change from the old version, which pulled out the name
 	// before the tagMapFunc could be run, but I think this is the right way
@@ -314,126 +2451,593 @@ func parseName(field reflect.StructField, tagName string, mapFunc, tagMapFunc ma
 	}
 
 	// finally, split the options from the name
-	parts := strings.Split(tag, ",")
+	parts := splitTag(tag)
 	fieldName = parts[0]
 
 	return tag, fieldName
 }
 
-// parseOptions parses options out of a tag string, skipping the name
-func parseOptions(tag string) map[string]string {
-	parts := strings.Split(tag, ",")
+// parseOptions parses options out of a tag string, skipping the name, it
+// returns the options and the option keys in order
+func parseOptions(tag string) (map[string]string, []string) {
+	parts := splitTag(tag)
 	options := make(map[string]string, len(parts))
+	var order []string
 	if len(parts) > 1 {
-		for _, opt := range parts[1:] {
-			// short circuit potentially expensive split op
-			if strings.Contains(opt, "=") {
-				kv := strings.Split(opt, "=")
-				options[kv[0]] = kv[1]
-				continue
+		order = addOptions(options, parts[1:])
+	}
+	return options, order
+}
+
+// addOptions parses the options and adds them to the options map, options
+// already in the map are overwritten.  It returns the option keys in order.
+func addOptions(options map[string]string, parts []string) []string {
+	order := make([]string, 0, len(parts))
+	for _, opt := range parts {
+		// short circuit potentially expensive split op
+		if strings.Contains(opt, "=") {
+			kv := strings.SplitN(opt, "=", 2)
+			options[kv[0]] = kv[1]
+			order = append(order, kv[0])
+			continue
+		}
+		options[opt] = ""
+		order = append(order, opt)
+	}
+	return order
+}
+
+// optionValues returns the non-empty values of the repeated key option in
+// parts in order, if trim is set spaces around the keys and values are
+// trimmed.
+func optionValues(parts []string, key string, trim bool) []string {
+	var values []string
+	for _, opt := range parts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k, v := kv[0], kv[1]
+		if trim {
+			k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		}
+		if k == key && v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// trimOptions returns options with leading and trailing spaces of keys and
+// values removed.
+func trimOptions(options map[string]string) map[string]string {
+	r := make(map[string]string, len(options))
+	for k, v := range options {
+		r[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return r
+}
+
+// splitTag splits a tag string on commas, a comma escaped with a backslash
+// is part of the name or option and it's unescaped.  An option value starting
+// with a single quote is quoted, commas in it are not split on and the quotes
+// are removed, ex. "oneof='a,b'" is "oneof=a,b".  Quotes elsewhere, ex. in
+// the name or in the middle of a value like "default=it's", are kept.
+// Malformed tags are split too, an unterminated quote extends to the end of
+// the tag and a backslash that does not escape a comma is kept, there is
+// always at least one part.
+func splitTag(tag string) []string {
+	parts, _ := splitTagParts(tag, 1)
+	return parts
+}
+
+// splitOptionsTag is like splitTag for tags without a name, every part is an
+// option, see NewMapperWithOptionsTag.
+func splitOptionsTag(tag string) []string {
+	parts, _ := splitTagParts(tag, 0)
+	return parts
+}
+
+// splitTagParts splits tag like splitTag, the first skip parts are names that
+// are never quoted.  It also reports whether the tag ends in a quoted value
+// that is not terminated.
+func splitTagParts(tag string, skip int) (parts []string, unterminated bool) {
+	// short circuit if there is nothing to unescape
+	if !strings.Contains(tag, `\,`) && !strings.Contains(tag, "='") {
+		return strings.Split(tag, ","), false
+	}
+
+	var (
+		b      strings.Builder
+		quoted bool
+		eq     bool
+	)
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		switch {
+		case c == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			b.WriteByte(',')
+			i++
+		case quoted:
+			if c == '\'' {
+				quoted = false
+			} else {
+				b.WriteByte(c)
+			}
+		case c == ',':
+			parts = append(parts, b.String())
+			b.Reset()
+			eq = false
+		case c == '=' && !eq && len(parts) >= skip:
+			// only the first character of the value opens a quote
+			eq = true
+			b.WriteByte(c)
+			if i+1 < len(tag) && tag[i+1] == '\'' {
+				quoted = true
+				i++
 			}
-			options[opt] = ""
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String()), quoted
+}
+
+// fieldPath returns the path of fi.
+func fieldPath(fi *FieldInfo) string {
+	return fi.Path
+}
+
+// isPromotedToRoot reports whether fi is a field of the root struct or it's
+// promoted to it through embedded structs.
+func isPromotedToRoot(fi *FieldInfo) bool {
+	for p := fi.Parent; p.Parent != nil; p = p.Parent {
+		if !p.Embedded {
+			return false
 		}
 	}
-	return options
+	return true
 }
 
-// getMapping returns a mapping for the t type, using the tagName, mapFunc and
-// tagMapFunc to determine the canonical names of fields.
-func getMapping(t reflect.Type, tagName string, mapFunc, tagMapFunc mapf) *StructMap {
-	m := []*FieldInfo{}
+// dominantFields indexes the included fields by key, ex. path, following the Go rules
+// for promoted fields, the field at the shallowest depth wins.  If there are
+// many fields with the same key at the shallowest depth the key is ambiguous
+// and it's left out.
+func dominantFields(fields []*FieldInfo, key func(fi *FieldInfo) string, include func(fi *FieldInfo) bool) map[string]*FieldInfo {
+	r := make(map[string]*FieldInfo, len(fields))
+	var ambiguous map[string]int // depth of the ambiguous keys
+	for _, fi := range fields {
+		if !include(fi) {
+			continue
+		}
+		k := key(fi)
+		if depth, ok := ambiguous[k]; ok {
+			if fi.Depth < depth {
+				delete(ambiguous, k)
+				r[k] = fi
+			}
+			continue
+		}
+		cur, ok := r[k]
+		switch {
+		case !ok || fi.Depth < cur.Depth:
+			r[k] = fi
+		case fi.Depth == cur.Depth:
+			delete(r, k)
+			if ambiguous == nil {
+				ambiguous = map[string]int{}
+			}
+			ambiguous[k] = fi.Depth
+		}
+	}
+	return r
+}
 
+// getMapping returns a mapping for the t type, using the tagNames, mapFunc and
+// tagMapFunc from opts to determine the canonical names of fields.
+func getMapping(t reflect.Type, opts *mapOptions) *StructMap {
+	t = Deref(t)
 	root := &FieldInfo{}
-	queue := []typeQueue{}
-	queue = append(queue, typeQueue{Deref(t), root, ""})
+	tagErrs := buildTree(t, typeQueue{t, root, "", ""}, opts, nil)
+
+	flds := newStructMap(root, opts)
+	if opts.strictTags {
+		for _, p := range shadowedPaths(flds.Index, opts.separator()) {
+			tagErrs = append(tagErrs, fmt.Sprintf("%s: path %q is shadowed by %s", p[0].FullName(), p[0].Path, p[1].FullName()))
+		}
+	}
+	if len(tagErrs) > 0 {
+		flds.err = fmt.Errorf("invalid tags in %s: %s", t, strings.Join(tagErrs, "; "))
+	}
+	return flds
+}
+
+// buildTree maps the fields of the struct of start and the fields of its
+// embedded and nested structs, recursively, as the children of start.fi and
+// returns the invalid tags, t is the root type.  If nested is set the nested
+// (not embedded) struct fields are passed to it instead of being mapped, it
+// gets the queue item that would map the fields of the nested struct.
+func buildTree(t reflect.Type, start typeQueue, opts *mapOptions, nested func(tq typeQueue)) []string {
+	queue := []typeQueue{start}
+
+	var tagErrs []string
 
-QueueLoop:
 	for len(queue) != 0 {
 		// pop the first item off of the queue
 		tq := queue[0]
 		queue = queue[1:]
 
-		// ignore recursive field
-		for p := tq.fi.Parent; p != nil; p = p.Parent {
-			if tq.fi.Field.Type == p.Field.Type {
-				continue QueueLoop
-			}
-		}
-
 		nChildren := 0
 		if tq.t.Kind() == reflect.Struct {
 			nChildren = tq.t.NumField()
 		}
 		tq.fi.Children = make([]*FieldInfo, nChildren)
 
+		// the fields, their caches and indexes are allocated at once for
+		// the whole struct
+		var (
+			infos   = make([]FieldInfo, nChildren)
+			parsed  = make([]parsedOptions, nChildren)
+			depth   = len(tq.fi.Index) + 1
+			indexes = make([]int, nChildren*depth)
+		)
+
 		// iterate through all of its fields
 		for fieldPos := 0; fieldPos < nChildren; fieldPos++ {
 
 			f := tq.t.Field(fieldPos)
 
 			// parse the tag and the target name using the mapping options for this field
-			tag, name := parseName(f, tagName, mapFunc, tagMapFunc)
+			tag, name := parseName(f, opts.tagNames, opts.mapFunc, opts.tagMapFunc)
+			if opts.stripPrefix != "" && strings.HasPrefix(tag, opts.stripPrefix) {
+				tag = tag[len(opts.stripPrefix):]
+				name = splitTag(tag)[0]
+			}
+			if opts.nameDelim != 0 {
+				if i := strings.IndexRune(tag, opts.nameDelim); i >= 0 {
+					tag = tag[:i]
+					name = splitTag(tag)[0]
+				}
+			}
+			if opts.trimTagSpace {
+				tag = strings.TrimSpace(tag)
+				name = strings.TrimSpace(name)
+			}
+
+			// fields without the tags are named by the json tag if it's
+			// enabled, the json options are ignored
+			fromJSON := false
+			if opts.jsonFallback {
+				if _, ok := lookupTag(f.Tag, opts.tagNames); !ok {
+					if v, ok := f.Tag.Lookup("json"); ok {
+						if v == "-" {
+							continue
+						}
+						if n := strings.SplitN(v, ",", 2)[0]; n != "" {
+							name = n
+							fromJSON = true
+						}
+					}
+				}
+			}
+
+			if opts.strictTags {
+				fullName := strings.Join(append(tq.fi.fieldNames(), f.Name), ".")
+				for _, msg := range checkTag(tag, 1) {
+					tagErrs = append(tagErrs, fullName+": "+msg)
+				}
+				if opts.optionsTag != "" {
+					for _, msg := range checkTag(f.Tag.Get(opts.optionsTag), 0) {
+						tagErrs = append(tagErrs, fullName+": "+msg)
+					}
+				}
+			}
 
-			// if the name is "-", disabled via a tag, skip it
-			if name == "-" {
+			// if the tag is "-" the field is disabled, skip it, note that "-,"
+			// is a field named "-" like in encoding/json
+			if tag == "-" || (tag == "" && name == "-" && !fromJSON) {
+				continue
+			}
+			if opts.skipFunc != nil && opts.skipFunc(f) {
 				continue
 			}
+			for _, fn := range opts.normalizers {
+				name = fn(name)
+			}
 
-			fi := FieldInfo{
-				Field:   f,
-				Name:    name,
-				Zero:    reflect.New(f.Type).Elem(),
-				Options: parseOptions(tag),
+			fi := &infos[fieldPos]
+			*fi = FieldInfo{
+				Field:  f,
+				Name:   name,
+				Zero:   reflect.New(f.Type).Elem(),
+				parsed: &parsed[fieldPos],
+			}
+			fi.RawTag, _ = lookupTag(f.Tag, opts.tagNames)
+			fi.Options, fi.OptionOrder = parseOptions(tag)
+			if strings.Contains(tag, "alias") {
+				fi.Aliases = optionValues(splitTag(tag)[1:], "alias", opts.trimTagSpace)
+			}
+			if opts.optionsTag != "" {
+				if v := f.Tag.Get(opts.optionsTag); v != "" {
+					fi.OptionOrder = append(fi.OptionOrder, addOptions(fi.Options, splitOptionsTag(v))...)
+					if strings.Contains(v, "alias") {
+						fi.Aliases = append(fi.Aliases, optionValues(splitOptionsTag(v), "alias", opts.trimTagSpace)...)
+					}
+				}
+			}
+			if opts.trimTagSpace {
+				fi.Options = trimOptions(fi.Options)
+				for i, k := range fi.OptionOrder {
+					fi.OptionOrder[i] = strings.TrimSpace(k)
+				}
+			}
+			for k := range opts.inherit {
+				if v, ok := tq.fi.Options[k]; ok {
+					if _, ok := fi.Options[k]; !ok {
+						fi.Options[k] = v
+					}
+				}
 			}
 
-			// if the path is empty this path is just the name
-			if tq.pp == "" {
+			// if the path is empty this path is just the name, fields without
+			// tags are named by the path func if it's set
+			if opts.pathFunc != nil && tag == "" && name != "" && !fromJSON {
+				fi.Name = opts.pathFunc(tq.fi.fieldNames(), f.Name)
 				fi.Path = fi.Name
+			} else if tq.pp == "" {
+				fi.Path = fi.Name
+			} else if tq.sep != "" {
+				fi.Path = tq.pp + tq.sep + fi.Name
 			} else {
-				fi.Path = tq.pp + "." + fi.Name
+				fi.Path = tq.pp + opts.separator() + fi.Name
 			}
 
-			// skip unexported fields
-			if len(f.PkgPath) != 0 && !f.Anonymous {
+			// skip unexported fields, unexported embedded structs may still
+			// promote exported fields, unexported tagged fields are mapped
+			// only if requested, exported struct fields with the inline
+			// option are mapped like embedded structs
+			ft := Deref(f.Type)
+			leaf := ft.Kind() != reflect.Struct || opts.isLeaf(ft)
+			_, inline := fi.Options["inline"]
+			inline = inline && !f.Anonymous && len(f.PkgPath) == 0
+			embedded := (f.Anonymous || inline) && !leaf
+			if len(f.PkgPath) != 0 && !embedded && !(opts.unexported && tag != "") {
 				continue
 			}
+			if (opts.requireTag && !embedded) || (opts.skipUntagged && embedded && f.Anonymous) {
+				if _, ok := lookupTag(f.Tag, opts.tagNames); !ok {
+					continue
+				}
+			}
+			if opts.validateOptions {
+				tagErrs = append(tagErrs, checkOptionKinds(fi, tq.fi)...)
+			}
 
-			// bfs search of anonymous embedded structs
-			if f.Anonymous {
+			// fields of structs with the flatten option are joined with the
+			// flatten separator
+			sep := ""
+			if _, ok := fi.Options["flatten"]; ok {
+				sep = opts.flattenSeparator()
+			}
+
+			// bfs search of anonymous embedded structs, embedded non-struct
+			// types like interfaces, leaf types and recursive fields are
+			// leaves
+			if embedded && !isRecursive(t, tq, ft) {
 				pp := tq.pp
-				if tag != "" {
+				if (tag != "" || opts.noPromote) && !inline {
 					pp = fi.Path
 				}
 
 				fi.Embedded = true
-				fi.Index = apnd(tq.fi.Index, fieldPos)
-				nChildren := 0
-				ft := Deref(f.Type)
-				if ft.Kind() == reflect.Struct {
-					nChildren = ft.NumField()
+				queue = append(queue, typeQueue{ft, fi, pp, sep})
+			} else if !leaf && !isRecursive(t, tq, ft) {
+				if nested != nil {
+					nested(typeQueue{ft, fi, fi.Path, sep})
+				} else {
+					queue = append(queue, typeQueue{ft, fi, fi.Path, sep})
 				}
-				fi.Children = make([]*FieldInfo, nChildren)
-				queue = append(queue, typeQueue{Deref(f.Type), &fi, pp})
-			} else if fi.Zero.Kind() == reflect.Struct || (fi.Zero.Kind() == reflect.Ptr && fi.Zero.Type().Elem().Kind() == reflect.Struct) {
-				fi.Index = apnd(tq.fi.Index, fieldPos)
-				fi.Children = make([]*FieldInfo, Deref(f.Type).NumField())
-				queue = append(queue, typeQueue{Deref(f.Type), &fi, fi.Path})
 			}
 
-			fi.Index = apnd(tq.fi.Index, fieldPos)
+			// the capacity is limited so that appending to the index does
+			// not overwrite the index of the next field
+			fi.Index = indexes[fieldPos*depth : (fieldPos+1)*depth : (fieldPos+1)*depth]
+			copy(fi.Index, tq.fi.Index)
+			fi.Index[depth-1] = fieldPos
+			fi.Depth = depth
 			fi.Parent = tq.fi
-			tq.fi.Children[fieldPos] = &fi
-			m = append(m, &fi)
+			tq.fi.Children[fieldPos] = fi
+		}
+
+		if opts.strictTags {
+			tagErrs = append(tagErrs, checkDuplicates(tq.fi)...)
+		}
+	}
+	return tagErrs
+}
+
+// checkTag returns the problems with the options of tag, the first skip parts
+// of the tag are not options.
+func checkTag(tag string, skip int) []string {
+	if tag == "" {
+		return nil
+	}
+
+	var msgs []string
+	parts, unterminated := splitTagParts(tag, skip)
+	if unterminated {
+		msgs = append(msgs, fmt.Sprintf("unterminated quote in tag %q", tag))
+	}
+	if len(parts) <= skip {
+		return msgs
+	}
+	for _, opt := range parts[skip:] {
+		if opt == "" || strings.HasPrefix(opt, "=") {
+			msgs = append(msgs, fmt.Sprintf("empty option key in tag %q", tag))
+			break
+		}
+	}
+	return msgs
+}
+
+// checkDuplicates returns the problems with the names of the fields of the
+// struct described by fi that have the same path.
+func checkDuplicates(fi *FieldInfo) []string {
+	var msgs []string
+	for _, fields := range duplicateChildren(fi) {
+		for _, c := range fields[1:] {
+			msgs = append(msgs, fmt.Sprintf("%s: duplicate name %q, used by %s", c.FullName(), c.Name, fields[0].FullName()))
+		}
+	}
+	return msgs
+}
+
+// duplicateChildren returns the groups of the children of fi that have the
+// same path, in declaration order.
+func duplicateChildren(fi *FieldInfo) [][]*FieldInfo {
+	var (
+		order []string
+		paths = map[string][]*FieldInfo{}
+	)
+	for _, c := range fi.Children {
+		if c == nil || c.Name == "" || c.Embedded {
+			continue
+		}
+		if _, ok := paths[c.Path]; !ok {
+			order = append(order, c.Path)
+		}
+		paths[c.Path] = append(paths[c.Path], c)
+	}
+
+	var r [][]*FieldInfo
+	for _, p := range order {
+		if len(paths[p]) > 1 {
+			r = append(r, paths[p])
+		}
+	}
+	return r
+}
+
+// shadowedPaths returns the fields whose paths are used by fields with the
+// path separator sep in their names, ex. a field tagged `db:"foo.bar"`
+// shadows field bar of a nested struct tagged `db:"foo"`, as pairs of the
+// shadowed field and the field shadowing it.
+func shadowedPaths(index []*FieldInfo, sep string) [][2]*FieldInfo {
+	var dotted map[string]*FieldInfo
+	for _, fi := range index {
+		if fi.Embedded || !strings.Contains(fi.Name, sep) {
+			continue
+		}
+		if dotted == nil {
+			dotted = map[string]*FieldInfo{}
+		}
+		if _, ok := dotted[fi.Path]; !ok {
+			dotted[fi.Path] = fi
 		}
 	}
+	if dotted == nil {
+		return nil
+	}
+
+	var r [][2]*FieldInfo
+	for _, fi := range index {
+		if d, ok := dotted[fi.Path]; ok && d != fi && d.Name != fi.Name && !fi.Embedded {
+			r = append(r, [2]*FieldInfo{fi, d})
+		}
+	}
+	return r
+}
+
+// newStructMap indexes the fields of the tree root.
+func newStructMap(root *FieldInfo, opts *mapOptions) *StructMap {
+	// index the fields in declaration order, the fields of embedded and
+	// nested structs follow the struct field
+	flds := &StructMap{
+		Index:      []*FieldInfo{},
+		Tree:       root,
+		setters:    &sync.Map{},
+		accessors:  &sync.Map{},
+		traversals: &sync.Map{},
+	}
+	if len(opts.tagNames) > 0 {
+		flds.tagName = opts.tagNames[0]
+	}
+	flds.Walk(func(fi *FieldInfo) bool {
+		flds.Index = append(flds.Index, fi)
+		return true
+	})
+	flds.Paths = dominantFields(flds.Index, fieldPath, func(fi *FieldInfo) bool {
+		return true
+	})
+	flds.Names = dominantFields(flds.Index, fieldPath, func(fi *FieldInfo) bool {
+		return fi.Name != "" && !fi.Embedded
+	})
+	flds.goNames = dominantFields(flds.Index, func(fi *FieldInfo) string {
+		return fi.Field.Name
+	}, isPromotedToRoot)
+
+	if opts.caseInsensitive {
+		flds.namesFold = foldNames(flds)
+	} else {
+		flds.namesFoldLazy = &lazyFoldIndex{}
+	}
+
+	flds.pathsFold = make(map[string]*FieldInfo, len(flds.Paths))
+	flds.Walk(func(fi *FieldInfo) bool {
+		if flds.Paths[fi.Path] != fi {
+			return true
+		}
+		key := strings.ToLower(fi.Path)
+		if _, ok := flds.pathsFold[key]; !ok {
+			flds.pathsFold[key] = fi
+		}
+		return true
+	})
+
+	flds.zeros = make(map[string]reflect.Value, len(flds.Names))
+	flds.columns = make(map[string][]int, len(flds.Names))
+	for name, fi := range flds.Names {
+		flds.zeros[name] = fi.Zero
+		flds.columns[name] = fi.Index
+	}
 
-	flds := &StructMap{Index: m, Tree: root, Paths: map[string]*FieldInfo{}, Names: map[string]*FieldInfo{}}
+	flds.flat = true
 	for _, fi := range flds.Index {
-		flds.Paths[fi.Path] = fi
-		if fi.Name != "" && !fi.Embedded {
-			flds.Names[fi.Path] = fi
+		if k := fi.Field.Type.Kind(); fi.Depth != 1 || k == reflect.Ptr || k == reflect.Map {
+			flds.flat = false
+			break
 		}
 	}
 
+	flds.aliases = map[string]*FieldInfo{}
+	flds.Walk(func(fi *FieldInfo) bool {
+		alias := fi.Options["name"]
+		if alias == "" || flds.Names[fi.Path] != fi {
+			return true
+		}
+		key := strings.TrimSuffix(fi.Path, fi.Name) + alias
+		if _, ok := flds.aliases[key]; !ok {
+			flds.aliases[key] = fi
+		}
+		return true
+	})
+
+	flds.nameAliases = map[string]*FieldInfo{}
+	flds.Walk(func(fi *FieldInfo) bool {
+		if len(fi.Aliases) == 0 || flds.Names[fi.Path] != fi {
+			return true
+		}
+		for _, alias := range fi.Aliases {
+			key := strings.TrimSuffix(fi.Path, fi.Name) + alias
+			if _, ok := flds.Names[key]; ok {
+				continue
+			}
+			if _, ok := flds.nameAliases[key]; !ok {
+				flds.nameAliases[key] = fi
+			}
+		}
+		return true
+	})
+
 	return flds
 }
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Step is a step of a traversal that may go through maps, it's either a field
+// index or a map key.
+type Step struct {
+	// Index is the index of the struct field, it's used if Key is nil.
+	Index int
+	// Key is the key of the map element.
+	Key interface{}
+}
+
+// IndexSteps returns the steps of the struct traversal index, ex. to append
+// map key steps to a traversal from StructMap.
+func IndexSteps(index []int) []Step {
+	steps := make([]Step, len(index))
+	for i, idx := range index {
+		steps[i].Index = idx
+	}
+	return steps
+}
+
+// FieldByPathSteps is like FieldByIndexesAlloc but the traversal can go
+// through maps, ex. for a field of type map[string]T the steps can be the
+// index of the field and a key followed by the indexes of a field of T.  Map
+// keys are converted to the key type of the map like in SetFieldValue.  Map
+// elements are not addressable, so values reached through a map can not be
+// set and nil pointers in them can not be allocated.  It returns an error if
+// a step does not match the kind of the value or a key is not in the map.
+func FieldByPathSteps(v reflect.Value, steps []Step) (reflect.Value, error) {
+	for _, s := range steps {
+		if s.Key == nil {
+			if k := indirect(v).Kind(); k != reflect.Struct {
+				return reflect.Value{}, fmt.Errorf("cannot get field %d of %s", s.Index, v.Type())
+			}
+			f, err := FieldByIndexesAlloc(v, []int{s.Index})
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v = f
+			continue
+		}
+
+		m := indirect(v)
+		if m.Kind() != reflect.Map {
+			return reflect.Value{}, fmt.Errorf("cannot get key %v of %s", s.Key, v.Type())
+		}
+		k, ok := assignable(reflect.ValueOf(s.Key), m.Type().Key())
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("invalid key %v for %s", s.Key, m.Type())
+		}
+		e := m.MapIndex(k)
+		if !e.IsValid() {
+			return reflect.Value{}, fmt.Errorf("key %v not found in %s", s.Key, m.Type())
+		}
+		v = e
+	}
+	return v, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldByPathSteps(t *testing.T) {
+	type Limit struct {
+		Max int `db:"max"`
+	}
+	type Context struct {
+		SessionID string `db:"sid"`
+	}
+	type Model struct {
+		*Context
+		Limits map[string]Limit  `db:"limits"`
+		Ptrs   map[int]*Limit    `db:"ptrs"`
+		Tags   map[string]string `db:"tags"`
+	}
+
+	m := NewMapper("db")
+	model := Model{
+		Limits: map[string]Limit{"a": {Max: 1}},
+		Ptrs:   map[int]*Limit{2: {Max: 2}},
+		Tags:   map[string]string{"k": "v"},
+	}
+	v := reflect.ValueOf(&model)
+	tm := m.TypeMap(v.Type())
+
+	steps := append(IndexSteps(tm.GetByPath("limits").Index), Step{Key: "a"}, Step{Index: 0})
+	if f, err := FieldByPathSteps(v, steps); err != nil || f.Interface() != 1 {
+		t.Errorf("Expecting %d, got %v %v", 1, f, err)
+	}
+	steps = append(IndexSteps(tm.GetByPath("ptrs").Index), Step{Key: 2.0}, Step{Index: 0})
+	f, err := FieldByPathSteps(v, steps)
+	if err != nil || f.Interface() != 2 {
+		t.Errorf("Expecting %d, got %v %v", 2, f, err)
+	}
+	f.SetInt(3)
+	if model.Ptrs[2].Max != 3 {
+		t.Errorf("Expecting %d, got %d", 3, model.Ptrs[2].Max)
+	}
+
+	f, err = FieldByPathSteps(v, IndexSteps(tm.GetByPath("sid").Index))
+	if err != nil || !f.CanSet() || model.Context == nil {
+		t.Errorf("Expecting allocated settable field, got %v %v", f, err)
+	}
+
+	errors := [][]Step{
+		append(IndexSteps(tm.GetByPath("tags").Index), Step{Key: "missing"}),
+		append(IndexSteps(tm.GetByPath("tags").Index), Step{Key: 1}),
+		append(IndexSteps(tm.GetByPath("tags").Index), Step{Index: 0}),
+		{{Key: "a"}},
+		append(IndexSteps(tm.GetByPath("ptrs").Index), Step{Key: 2.5}),
+	}
+	for _, steps := range errors {
+		if _, err := FieldByPathSteps(v, steps); err == nil {
+			t.Errorf("Expecting error for %v", steps)
+		}
+	}
+}
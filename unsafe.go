@@ -0,0 +1,34 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import "reflect"
+
+// FieldByIndexesUnsafe is like FieldByIndexes but it also returns settable
+// values for unexported fields, see Mapper.IncludeUnexported.  It bypasses the
+// reflect package's protection of unexported fields with reflect.NewAt, the
+// returned value can be set and used with Interface.  This breaks the
+// encapsulation of the types involved, use it only with types that are known
+// to be safe to modify this way.  The v must be addressable, otherwise
+// unexported fields are returned read-only.
+func FieldByIndexesUnsafe(v reflect.Value, indexes []int) reflect.Value {
+	for _, i := range indexes {
+		v = indirect(v).Field(i)
+		if !v.CanSet() && v.CanAddr() {
+			v = reflect.NewAt(v.Type(), v.Addr().UnsafePointer()).Elem()
+		}
+		// if this is a pointer and it's nil, allocate a new value and set it,
+		// pointers to pointers are allocated at every level
+		for p := v; p.Kind() == reflect.Ptr; p = p.Elem() {
+			if p.IsNil() {
+				p.Set(reflect.New(p.Type().Elem()))
+			}
+		}
+		if v.Kind() == reflect.Map && v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	}
+	return v
+}
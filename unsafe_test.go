@@ -0,0 +1,61 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIncludeUnexported(t *testing.T) {
+	type details struct {
+		Active bool `db:"active"`
+	}
+	type Foo struct {
+		A       int      `db:"a"`
+		b       int      `db:"b"`
+		c       int      // nolint
+		details *details `db:"details"`
+	}
+
+	m := NewMapper("db")
+	if _, ok := m.TypeMap(reflect.TypeOf(Foo{})).Names["b"]; ok {
+		t.Error("Expecting unexported fields to be skipped by default")
+	}
+
+	m.IncludeUnexported(true)
+	fields := m.TypeMap(reflect.TypeOf(Foo{}))
+	for _, name := range []string{"a", "b", "details", "details.active"} {
+		if _, ok := fields.Names[name]; !ok {
+			t.Errorf("Expecting to find key %s in mapping but did not.", name)
+		}
+	}
+	if _, ok := fields.Names["c"]; ok {
+		t.Error("Expecting untagged unexported field to be skipped")
+	}
+
+	f := Foo{A: 1, b: 2}
+	if v := m.FieldByName(reflect.ValueOf(f), "b"); v.Int() != 2 || v.CanSet() {
+		t.Errorf("Expecting read-only %d, got %v", f.b, v)
+	}
+	if r := m.ToMap(reflect.ValueOf(f)); len(r) != 1 || r["a"] != 1 {
+		t.Errorf("Expecting only exported fields, got %v", r)
+	}
+
+	fv := reflect.ValueOf(&f)
+	v := FieldByIndexesUnsafe(fv, fields.Names["b"].Index)
+	v.SetInt(3)
+	if f.b != 3 {
+		t.Errorf("Expecting %d, got %d", 3, f.b)
+	}
+	v = FieldByIndexesUnsafe(fv, fields.Names["details.active"].Index)
+	v.SetBool(true)
+	if f.details == nil || !f.details.Active {
+		t.Errorf("Expecting %v, got %+v", true, f.details)
+	}
+	if v := FieldByIndexesUnsafe(fv, fields.Names["a"].Index); v.Interface() != f.A {
+		t.Errorf("Expecting %d, got %v", f.A, v)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks the fields of v against the constraints given as tag
+// options and returns an error for every violation, the errors start with the
+// mapped name of the field.  Supported options are:
+//
+//	required  the field must not be the zero value of its type, unless
+//	          the option is set to false ex. required=false
+//	min=N     the numeric field must not be less than N
+//	max=N     the numeric field must not be greater than N
+//	maxlen=N  the string field must not be longer than N characters, the
+//	          slice field must not have more than N elements
+//
+// Fields behind nil pointers are zero, only required is checked for them.
+// Panics if v's Kind is not Struct or v is not Indirectable to a struct Kind.
+func (m *Mapper) Validate(v reflect.Value) []error {
+	v = reflect.Indirect(v)
+	mustBe(v, reflect.Struct)
+
+	var errs []error
+	tm := m.TypeMap(v.Type())
+	tm.Walk(func(fi *FieldInfo) bool {
+		if tm.Names[fi.Path] != fi {
+			return true
+		}
+		errs = append(errs, validateField(v, fi)...)
+		return true
+	})
+	return errs
+}
+
+func validateField(root reflect.Value, fi *FieldInfo) []error {
+	var errs []error
+
+	f, ok := fieldByIndexesReadOnly(root, fi.Index)
+	if fi.ParsedOptions().Required && (!ok || f.IsZero()) {
+		errs = append(errs, fmt.Errorf("%s: required field is not set", fi.Path))
+	}
+	if !ok {
+		return errs
+	}
+	f = indirect(f)
+	if !f.IsValid() {
+		return errs
+	}
+
+	var (
+		n       float64
+		numeric = true
+	)
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(f.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n = float64(f.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = f.Float()
+	default:
+		numeric = false
+	}
+
+	if numeric {
+		if min, ok, err := numberOption(fi, "min"); err != nil {
+			errs = append(errs, err)
+		} else if ok && n < min {
+			errs = append(errs, fmt.Errorf("%s: %v is less than min %v", fi.Path, n, min))
+		}
+		if max, ok, err := numberOption(fi, "max"); err != nil {
+			errs = append(errs, err)
+		} else if ok && n > max {
+			errs = append(errs, fmt.Errorf("%s: %v is greater than max %v", fi.Path, n, max))
+		}
+	}
+
+	if k := f.Kind(); k == reflect.String || k == reflect.Slice {
+		if maxlen, ok, err := numberOption(fi, "maxlen"); err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			l := f.Len()
+			if k == reflect.String {
+				l = utf8.RuneCountInString(f.String())
+			}
+			if float64(l) > maxlen {
+				errs = append(errs, fmt.Errorf("%s: length %d is greater than maxlen %v", fi.Path, l, maxlen))
+			}
+		}
+	}
+
+	return errs
+}
+
+// numberOption returns the value of a numeric option, it returns an error if
+// the option is set but it's not a number.
+func numberOption(fi *FieldInfo, key string) (float64, bool, error) {
+	if _, ok := fi.StringOption(key); !ok {
+		return 0, false, nil
+	}
+	v, ok := fi.FloatOption(key)
+	if !ok {
+		return 0, false, fmt.Errorf("%s: invalid %s option %q", fi.Path, key, fi.Options[key])
+	}
+	return v, true, nil
+}
+
+// checkOptionKinds returns the problems with the validation options of fi
+// that do not apply to the kind of the field, parent is the parent of fi.
+func checkOptionKinds(fi, parent *FieldInfo) []string {
+	var msgs []string
+	k := Deref(fi.Field.Type).Kind()
+	for _, key := range []string{"min", "max", "maxlen"} {
+		if _, ok := fi.Options[key]; !ok {
+			continue
+		}
+		valid := isNumber(k)
+		if key == "maxlen" {
+			valid = k == reflect.String || k == reflect.Slice
+		}
+		if !valid {
+			fullName := strings.Join(append(parent.fieldNames(), fi.Field.Name), ".")
+			msgs = append(msgs, fmt.Sprintf("%s: %s option on %s field", fullName, key, k))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package reflectx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid,required,maxlen=4"`
+	}
+	type Request struct {
+		*Context
+		Query string   `http:"q,maxlen=3"`
+		Max   int      `http:"max,required,max=100"`
+		Page  uint     `http:"page,min=1"`
+		Ratio float64  `http:"ratio,min=0,max=1"`
+		Bad   int      `http:"bad,min=x"`
+		Opt   *int     `http:"opt,min=1"`
+		Tags  []string `http:"tags,maxlen=2"`
+		Note  string   `http:"note,required=false"`
+	}
+
+	m := NewMapper("http")
+	zero, ten := 0, 10
+
+	testCases := []struct {
+		value    Request
+		expected []string
+	}{
+		{
+			Request{Context: &Context{SessionID: "id"}, Query: "foo", Max: 100, Page: 1, Ratio: 0.5, Opt: &ten, Tags: []string{"a", "b"}},
+			[]string{
+				`bad: invalid min option "x"`,
+			},
+		},
+		{
+			Request{Query: "zażółć", Page: 0, Ratio: 1.5, Opt: &zero},
+			[]string{
+				"sid: required field is not set",
+				"q: length 6 is greater than maxlen 3",
+				"max: required field is not set",
+				"page: 0 is less than min 1",
+				"ratio: 1.5 is greater than max 1",
+				`bad: invalid min option "x"`,
+				"opt: 0 is less than min 1",
+			},
+		},
+		{
+			Request{Context: &Context{SessionID: "session"}, Max: 101, Page: 1, Tags: []string{"a", "b", "c"}},
+			[]string{
+				"sid: length 7 is greater than maxlen 4",
+				"max: 101 is greater than max 100",
+				`bad: invalid min option "x"`,
+				"tags: length 3 is greater than maxlen 2",
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		var errs []string
+		for _, err := range m.Validate(reflect.ValueOf(&tc.value)) {
+			errs = append(errs, err.Error())
+		}
+		if !reflect.DeepEqual(errs, tc.expected) {
+			t.Errorf("%d: expected %q, got %q", i, tc.expected, errs)
+		}
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	type Context struct {
+		SessionID string `http:"sid,min=1"`
+	}
+	type Request struct {
+		Context
+		Query string   `http:"q,max=100"`
+		Tags  []string `http:"tags,maxlen=3"`
+		Max   *int     `http:"max,min=0,max=100"`
+		Limit int      `http:"limit,maxlen=10"`
+		Name  string   `http:"name,maxlen=10,custom=1"`
+	}
+
+	m := NewMapper("http")
+	typ := reflect.TypeOf(Request{})
+	if _, err := m.TypeMapE(typ); err != nil {
+		t.Errorf("Expecting no error if not validated, got %s", err)
+	}
+
+	m.ValidateOptions(true)
+	tm, err := m.TypeMapE(typ)
+	if err == nil {
+		t.Fatal("Expecting error")
+	}
+	if tm == nil || tm.GetByPath("q") == nil {
+		t.Error("Expecting mapping to be returned")
+	}
+	for _, s := range []string{
+		"Context.SessionID: min option on string field",
+		"Query: max option on string field",
+		"Limit: maxlen option on int field",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Expecting error to contain %s, got %s", s, err)
+		}
+	}
+	for _, s := range []string{"Tags", "Max:", "Name"} {
+		if strings.Contains(err.Error(), s) {
+			t.Errorf("Unexpected error for %s, got %s", s, err)
+		}
+	}
+}